@@ -0,0 +1,69 @@
+/*
+Copyright 2024 The KCL authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+const (
+	// PlannedCondition indicates the result of the server-side dry-run
+	// of a KCLRun in Plan mode.
+	PlannedCondition string = "Planned"
+)
+
+const (
+	// PlanSucceededReason represents the fact that the changes of a KCLRun
+	// in Plan mode have been computed successfully.
+	PlanSucceededReason string = "PlanSucceeded"
+
+	// PlanFailedReason represents the fact that the server-side dry-run
+	// of a KCLRun in Plan mode failed.
+	PlanFailedReason string = "PlanFailed"
+
+	// TargetNamespaceFailedReason represents the fact that the target namespace
+	// could not be set on the compiled objects.
+	TargetNamespaceFailedReason string = "TargetNamespaceFailed"
+
+	// CompileFailedReason represents the fact that the KCL package
+	// could not be compiled into Kubernetes manifests.
+	CompileFailedReason string = "CompileFailed"
+
+	// CompileTimeoutReason represents the fact that the KCL compilation
+	// did not finish within the KCLRun timeout.
+	CompileTimeoutReason string = "CompileTimeout"
+
+	// DependencyResolutionFailedReason represents the fact that the KCL module
+	// dependencies could not be resolved.
+	DependencyResolutionFailedReason string = "DependencyResolutionFailed"
+
+	// LockMismatchReason represents the fact that the resolved KCL module
+	// dependencies do not match the kcl.mod.lock of the source.
+	LockMismatchReason string = "LockMismatch"
+
+	// OutputPushFailedReason represents the fact that the rendered manifests
+	// could not be pushed to the output OCI repository.
+	OutputPushFailedReason string = "OutputPushFailed"
+
+	// PatchFailedReason represents the fact that the patches could not be
+	// applied to the compiled objects.
+	PatchFailedReason string = "PatchFailed"
+
+	// PostBuildFailedReason represents the fact that the variables could not
+	// be substituted in the compiled objects.
+	PostBuildFailedReason string = "PostBuildFailed"
+
+	// DecryptionFailedReason represents the fact that the SOPS encrypted data
+	// could not be decrypted.
+	DecryptionFailedReason string = "DecryptionFailed"
+)
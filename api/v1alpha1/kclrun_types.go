@@ -17,6 +17,7 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"slices"
 	"time"
 
 	"github.com/fluxcd/pkg/apis/meta"
@@ -32,9 +33,45 @@ const (
 	MergeValue                = "Merge"
 	IfNotPresentValue         = "IfNotPresent"
 	IgnoreValue               = "Ignore"
+	// TargetNamespaceArgument is the name of the KCL top level argument
+	// holding the namespace the namespaced objects are applied to, when
+	// exposed with ExposeTargetNamespace.
+	TargetNamespaceArgument = "targetNamespace"
+	// SubstituteAnnotation is the annotation, or label, which disables the
+	// variable substitution of an object when set to 'disabled'.
+	SubstituteAnnotation = "krm.kcl.dev.fluxcd/substitute"
+)
+
+const (
+	// RawArgumentFormat passes the referenced value verbatim as a KCL string.
+	RawArgumentFormat = "raw"
+	// JSONArgumentFormat decodes the referenced value from JSON.
+	JSONArgumentFormat = "json"
+	// YAMLArgumentFormat decodes the referenced value from YAML.
+	YAMLArgumentFormat = "yaml"
+)
+
+const (
+	// ApplyMode instructs the controller to apply the compiled manifests
+	// on the cluster.
+	ApplyMode = "Apply"
+	// PlanMode instructs the controller to only compute the changes the
+	// compiled manifests would make, without mutating the cluster.
+	PlanMode = "Plan"
+)
+
+const (
+	// StrictLockMode fails the compilation if the kcl.mod.lock is missing
+	// or if resolving the dependencies would change it.
+	StrictLockMode = "Strict"
+	// UpdateLockMode lets the dependency resolution update the kcl.mod.lock.
+	UpdateLockMode = "Update"
+	// VendorLockMode requires the dependencies to be vendored in the source.
+	VendorLockMode = "Vendor"
 )
 
 // KCLRunSpec defines the desired state of KCLRun
+// +kubebuilder:validation:XValidation:rule="[has(self.sourceRef), has(self.inline), has(self.module)].exists_one(x, x)",message="exactly one of sourceRef, inline or module must be set"
 type KCLRunSpec struct {
 	// CommonMetadata specifies the common labels and annotations that are
 	// applied to all resources. Any existing label or annotation will be
@@ -90,6 +127,19 @@ type KCLRunSpec struct {
 	// +optional
 	TargetNamespace string `json:"targetNamespace,omitempty" yaml:"targetNamespace,omitempty"`
 
+	// StrictTargetNamespace instructs the controller to fail the reconciliation
+	// when a namespaced object explicitly targets a namespace other than the
+	// TargetNamespace. Defaults to false.
+	// +optional
+	StrictTargetNamespace bool `json:"strictTargetNamespace,omitempty" yaml:"strictTargetNamespace,omitempty"`
+
+	// ExposeTargetNamespace passes the TargetNamespace, or the namespace of the
+	// KCLRun, to the KCL program as the 'targetNamespace' top level argument.
+	// The argument references and the config arguments take precedence over it.
+	// Defaults to false.
+	// +optional
+	ExposeTargetNamespace bool `json:"exposeTargetNamespace,omitempty" yaml:"exposeTargetNamespace,omitempty"`
+
 	// Force instructs the controller to recreate resources
 	// when patching fails due to an immutable field change.
 	// +kubebuilder:default:=false
@@ -114,6 +164,7 @@ type KCLRunSpec struct {
 
 	// Path to the directory containing the kcl.mod file.
 	// Defaults to 'None', which translates to the root path of the SourceRef.
+	// Ignored when Inline is set.
 	// +optional
 	Path string `json:"path,omitempty" yaml:"path,omitempty"`
 
@@ -121,8 +172,10 @@ type KCLRunSpec struct {
 	// +optional
 	Config *ConfigSpec `json:"config,omitempty" yaml:"config,omitempty"`
 
-	// ArgumentReferences holds references to ConfigMaps and Secrets containing
-	// the KCL compile config. The ConfigMap and the Secret data keys represent the config names.
+	// ArgumentReferences holds references to ConfigMaps, Secrets and Vault KV secrets
+	// containing the KCL compile config. Their data keys represent the config names.
+	// Later references take precedence over earlier ones, and the Config.Arguments
+	// take precedence over all of them.
 	// +optional
 	ArgumentsReferences []ArgumentReference `json:"argumentsReferences,omitempty" yaml:"argumentsReferences,omitempty"`
 
@@ -140,13 +193,298 @@ type KCLRunSpec struct {
 	Wait bool `json:"wait,omitempty"`
 
 	// Reference of the source where the kcl file is.
-	// +required
-	SourceRef CrossNamespaceSourceReference `json:"sourceRef"`
+	// Mutually exclusive with Inline and Module.
+	// +optional
+	SourceRef *CrossNamespaceSourceReference `json:"sourceRef,omitempty"`
+
+	// Inline is the KCL source code compiled instead of the artifact of a
+	// SourceRef. Mutually exclusive with SourceRef and Module.
+	// +optional
+	Inline *InlineSource `json:"inline,omitempty" yaml:"inline,omitempty"`
+
+	// Module is a KCL package published to an OCI registry, pulled by the
+	// controller at every interval instead of the artifact of a SourceRef.
+	// Mutually exclusive with SourceRef and Inline.
+	// +optional
+	Module *ModuleSource `json:"module,omitempty" yaml:"module,omitempty"`
 
 	// This flag tells the controller to suspend subsequent kustomize executions,
 	// it does not apply to already started executions. Defaults to false.
 	// +optional
 	Suspend bool `json:"suspend,omitempty"`
+
+	// Mode of the reconciliation, valid values are ('Apply', 'Plan').
+	// In Plan mode the compiled manifests are diffed against the cluster with
+	// a server-side dry-run and the changes are recorded in status.lastPlan,
+	// without applying, pruning, storing or health checking anything. The result
+	// is reported by the Planned condition, and the Ready condition is left Unknown
+	// so that the dependents of the KCLRun are not reconciled. Defaults to 'Apply'.
+	// +kubebuilder:validation:Enum=Apply;Plan
+	// +kubebuilder:default:=Apply
+	// +optional
+	Mode string `json:"mode,omitempty" yaml:"mode,omitempty"`
+
+	// Rollback holds the configuration of the rollback to the last successfully
+	// applied manifests when the health checks fail.
+	// +optional
+	Rollback *RollbackSpec `json:"rollback,omitempty" yaml:"rollback,omitempty"`
+
+	// Output holds the configuration of the storage and the publication of the
+	// rendered manifests.
+	// +optional
+	Output *OutputSpec `json:"output,omitempty" yaml:"output,omitempty"`
+
+	// Patches is a list of strategic merge or JSON6902 patches applied in order
+	// to the compiled objects, before they are applied to the cluster.
+	// +optional
+	Patches []Patch `json:"patches,omitempty" yaml:"patches,omitempty"`
+
+	// Images is a list of (image name, new name, new tag or digest)
+	// for overriding the images of the containers and init containers
+	// of the compiled workloads.
+	// +optional
+	Images []Image `json:"images,omitempty" yaml:"images,omitempty"`
+
+	// PostBuild describes which actions to perform on the compiled objects
+	// before they are applied to the cluster.
+	// +optional
+	PostBuild *PostBuild `json:"postBuild,omitempty" yaml:"postBuild,omitempty"`
+
+	// Decryption holds the configuration of the decryption of the SOPS
+	// encrypted Secrets of the compiled objects and of the SOPS encrypted
+	// values of the argument Secrets. The compiled Secrets are decrypted before
+	// the patches, the images and the post-build substitution are applied.
+	// +optional
+	Decryption *Decryption `json:"decryption,omitempty" yaml:"decryption,omitempty"`
+}
+
+// Decryption defines how the SOPS encrypted data is decrypted.
+type Decryption struct {
+	// Provider is the name of the decryption engine.
+	// +kubebuilder:validation:Enum=sops
+	// +required
+	Provider string `json:"provider" yaml:"provider"`
+
+	// SecretRef is the Secret holding the private keys used for decryption,
+	// in the same namespace as the KCLRun. The age identities are read from
+	// the data keys ending with '.agekey', and the armored OpenPGP private
+	// keys from the data keys ending with '.asc'.
+	// +required
+	SecretRef *meta.LocalObjectReference `json:"secretRef" yaml:"secretRef"`
+}
+
+// PostBuild describes the variable substitution performed on the compiled objects.
+type PostBuild struct {
+	// Substitute holds a map of key/value pairs.
+	// The variables defined in the compiled objects and referred to with ${var}
+	// or ${var:=default} are substituted with the values of this map.
+	// The values of Substitute take precedence over the values of SubstituteFrom.
+	// +optional
+	Substitute map[string]string `json:"substitute,omitempty" yaml:"substitute,omitempty"`
+
+	// SubstituteFrom holds references to ConfigMaps and Secrets containing
+	// the variables and their values to be substituted in the compiled objects.
+	// The values of a reference override the values of the preceding references.
+	// +optional
+	SubstituteFrom []SubstituteReference `json:"substituteFrom,omitempty" yaml:"substituteFrom,omitempty"`
+
+	// Strict fails the reconciliation when a variable without default value
+	// is not defined, instead of substituting it with an empty string.
+	// +optional
+	Strict bool `json:"strict,omitempty" yaml:"strict,omitempty"`
+}
+
+// SubstituteReference contains a reference to a resource containing
+// the variables name and value.
+type SubstituteReference struct {
+	// Kind of the values referent, valid values are ('Secret', 'ConfigMap').
+	// +kubebuilder:validation:Enum=Secret;ConfigMap
+	// +required
+	Kind string `json:"kind" yaml:"kind"`
+
+	// Name of the values referent. Should reside in the same namespace as the
+	// referring resource.
+	// +kubebuilder:validation:MinLength=1
+	// +kubebuilder:validation:MaxLength=253
+	// +required
+	Name string `json:"name" yaml:"name"`
+
+	// Optional indicates whether the referenced resource must exist, or whether to
+	// tolerate its absence. If true and the referenced resource is absent, proceed
+	// as if the resource was present but empty, without any variables defined.
+	// +kubebuilder:default:=false
+	// +optional
+	Optional bool `json:"optional,omitempty" yaml:"optional,omitempty"`
+}
+
+// Image contains an image name, a new name, a new tag or digest,
+// which will replace the original name and tag.
+type Image struct {
+	// Name is a tag-less image name.
+	// +kubebuilder:validation:MinLength=1
+	// +required
+	Name string `json:"name" yaml:"name"`
+
+	// NewName is the value used to replace the original name.
+	// +optional
+	NewName string `json:"newName,omitempty" yaml:"newName,omitempty"`
+
+	// NewTag is the value used to replace the original tag.
+	// +optional
+	NewTag string `json:"newTag,omitempty" yaml:"newTag,omitempty"`
+
+	// Digest is the value used to replace the original image tag.
+	// If digest is present NewTag value is ignored.
+	// +optional
+	Digest string `json:"digest,omitempty" yaml:"digest,omitempty"`
+}
+
+// Patch contains a strategic merge or a JSON6902 patch, and the target
+// the patch is applied to.
+type Patch struct {
+	// Patch is the content of a strategic merge patch or a JSON6902 patch,
+	// written in YAML or JSON.
+	// +kubebuilder:validation:MinLength=1
+	// +required
+	Patch string `json:"patch" yaml:"patch"`
+
+	// Target selects the objects the patch is applied to. A strategic merge
+	// patch without target is applied to the object matching its apiVersion,
+	// kind, name and namespace. A JSON6902 patch requires a target.
+	// +optional
+	Target *Selector `json:"target,omitempty" yaml:"target,omitempty"`
+}
+
+// Selector specifies a set of objects. An object matches the selector
+// if it matches all the fields set in the selector.
+type Selector struct {
+	// Group is the API group to select objects from.
+	// +optional
+	Group string `json:"group,omitempty" yaml:"group,omitempty"`
+
+	// Version of the API group to select objects from.
+	// +optional
+	Version string `json:"version,omitempty" yaml:"version,omitempty"`
+
+	// Kind of the API group to select objects from.
+	// +optional
+	Kind string `json:"kind,omitempty" yaml:"kind,omitempty"`
+
+	// Namespace to select objects from, matched as an anchored regular expression.
+	// +optional
+	Namespace string `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+
+	// Name to match objects, matched as an anchored regular expression.
+	// +optional
+	Name string `json:"name,omitempty" yaml:"name,omitempty"`
+
+	// AnnotationSelector is a string that follows the label selection expression
+	// https://kubernetes.io/docs/concepts/overview/working-with-objects/labels/#api
+	// It matches with the object annotations.
+	// +optional
+	AnnotationSelector string `json:"annotationSelector,omitempty" yaml:"annotationSelector,omitempty"`
+
+	// LabelSelector is a string that follows the label selection expression
+	// https://kubernetes.io/docs/concepts/overview/working-with-objects/labels/#api
+	// It matches with the object labels.
+	// +optional
+	LabelSelector string `json:"labelSelector,omitempty" yaml:"labelSelector,omitempty"`
+}
+
+// OutputSpec defines where the rendered manifests are stored and published.
+type OutputSpec struct {
+	// Kind of the object owned by the KCLRun in which the gzip compressed manifests
+	// rendered for the last attempted revision are stored, valid values are
+	// ('Secret', 'ConfigMap'). Defaults to 'Secret'. The values of the Secrets are
+	// redacted from the manifests stored in a ConfigMap.
+	// +kubebuilder:validation:Enum=Secret;ConfigMap
+	// +kubebuilder:default:=Secret
+	// +optional
+	Kind string `json:"kind,omitempty" yaml:"kind,omitempty"`
+
+	// OCI is the OCI repository the rendered manifests are pushed to as an
+	// artifact tagged with the source revision, which can be consumed with
+	// an OCIRepository. The values of the Secrets are redacted from the
+	// pushed manifests.
+	// +optional
+	OCI *OCIOutput `json:"oci,omitempty" yaml:"oci,omitempty"`
+}
+
+// OCIOutput defines the OCI repository the rendered manifests are pushed to.
+type OCIOutput struct {
+	// URL is the OCI repository, e.g. 'oci://ghcr.io/org/manifests'.
+	// +kubebuilder:validation:Pattern="^oci://.+$"
+	// +required
+	URL string `json:"url" yaml:"url"`
+
+	// SecretRef is the reference to a Secret in the same namespace, of type
+	// 'kubernetes.io/dockerconfigjson', holding the registry credentials.
+	// The Secret is read with the service account the KCLRun impersonates, if any.
+	// +optional
+	SecretRef *meta.LocalObjectReference `json:"secretRef,omitempty" yaml:"secretRef,omitempty"`
+
+	// PlainHTTP pushes the artifact over plain HTTP.
+	// +optional
+	PlainHTTP bool `json:"plainHTTP,omitempty" yaml:"plainHTTP,omitempty"`
+}
+
+// RollbackSpec defines the rollback to the last successfully applied manifests.
+type RollbackSpec struct {
+	// Enable instructs the controller to store the last successfully applied
+	// manifests in a Secret owned by the KCLRun, and to re-apply them when the
+	// health checks of a new revision or config fail. A rolled back revision is not
+	// applied again until the revision or the config changes. Defaults to false.
+	// +optional
+	Enable bool `json:"enable,omitempty" yaml:"enable,omitempty"`
+}
+
+// InlineSource contains the KCL source code of a scratch package.
+type InlineSource struct {
+	// Code is the KCL code of the main.k entry file.
+	// +kubebuilder:validation:MinLength=1
+	// +required
+	Code string `json:"code" yaml:"code"`
+
+	// KCLMod is the content of the kcl.mod file declaring the
+	// package dependencies.
+	// +optional
+	KCLMod string `json:"kclMod,omitempty" yaml:"kclMod,omitempty"`
+}
+
+// ModuleSource contains the reference of a KCL package published to an OCI registry.
+// +kubebuilder:validation:XValidation:rule="[has(self.tag), has(self.semver), has(self.digest)].filter(x, x).size() <= 1",message="at most one of tag, semver or digest can be set"
+type ModuleSource struct {
+	// URL is the OCI repository of the KCL package, e.g. 'oci://ghcr.io/kcl-lang/helloworld'.
+	// +kubebuilder:validation:Pattern="^oci://.+$"
+	// +required
+	URL string `json:"url" yaml:"url"`
+
+	// Tag of the KCL package to pull. Defaults to 'latest' when neither
+	// SemVer nor Digest are set.
+	// +optional
+	Tag string `json:"tag,omitempty" yaml:"tag,omitempty"`
+
+	// SemVer is the range of versions, e.g. '>=1.0.0 <2.0.0' or '1.x', of which the
+	// highest tag of the KCL package is pulled. The versions of the range are complete
+	// semantic versions.
+	// +optional
+	SemVer string `json:"semver,omitempty" yaml:"semver,omitempty"`
+
+	// Digest is the manifest digest of the KCL package to pull, e.g. 'sha256:...'.
+	// +kubebuilder:validation:Pattern="^[a-z0-9]+(?:[.+_-][a-z0-9]+)*:[a-zA-Z0-9=_-]+$"
+	// +optional
+	Digest string `json:"digest,omitempty" yaml:"digest,omitempty"`
+
+	// PlainHTTP pulls the KCL package over plain HTTP.
+	// +optional
+	PlainHTTP bool `json:"plainHTTP,omitempty" yaml:"plainHTTP,omitempty"`
+
+	// RefuseFailedVersions instructs the controller to refuse a version selected
+	// by the SemVer range whose compilation or health checks fail. A refused version
+	// is recorded in the failed module versions and skipped by the following selections,
+	// so that the previous version is kept until a higher version is published.
+	// +optional
+	RefuseFailedVersions bool `json:"refuseFailedVersions,omitempty" yaml:"refuseFailedVersions,omitempty"`
 }
 
 // CommonMetadata defines the common labels and annotations.
@@ -161,10 +499,22 @@ type CommonMetadata struct {
 }
 
 // ConfigSpec defines the compile config.
+// +kubebuilder:validation:XValidation:rule="!has(self.files) || !has(self.profile)",message="files and profile are mutually exclusive"
 type ConfigSpec struct {
 	// Arguments is the list of top level dynamic arguments for the kcl option function, e.g., env="prod"
 	// +optional
 	Arguments []string `json:"arguments,omitempty" yaml:"arguments,omitempty"`
+	// Files is the list of KCL entry files, relative to the path, compiled instead
+	// of the package default entries.
+	// +optional
+	Files []string `json:"files,omitempty" yaml:"files,omitempty"`
+	// Profile is the name of the profile of the kcl.mod file whose 'entries' list is compiled,
+	// and checked to exist in the artifact. The 'default' profile is the '[profile]' table,
+	// the only profile defined by KCL. The entries are relative to the path, or to the root
+	// of the package when they start with '${KCL_MOD}'.
+	// +kubebuilder:validation:Enum=default
+	// +optional
+	Profile string `json:"profile,omitempty" yaml:"profile,omitempty"`
 	// Settings is the list of kcl setting files including all of the CLI config.
 	// +optional
 	Settings []string `json:"settings,omitempty" yaml:"settings,omitempty"`
@@ -186,16 +536,76 @@ type ConfigSpec struct {
 	// DisableNone denotes running kcl and disable dumping None values.
 	// +optional
 	DisableNone bool `json:"disableNone,omitempty" yaml:"disableNone,omitempty"`
+	// ModuleMirror is the OCI registry the KCL module dependencies are resolved from,
+	// and the rewrite rules of their sources, it takes precedence over the controller defaults.
+	// +optional
+	ModuleMirror *ModuleMirror `json:"moduleMirror,omitempty" yaml:"moduleMirror,omitempty"`
+	// ModuleCacheRef is a reference to an OCIRepository in the same namespace whose
+	// artifact is a pre-populated KCL module cache, used as a read-only cache to
+	// resolve the module dependencies without network access.
+	// +optional
+	ModuleCacheRef *meta.LocalObjectReference `json:"moduleCacheRef,omitempty" yaml:"moduleCacheRef,omitempty"`
+	// LockMode defines how the kcl.mod.lock of the KCL package is enforced, valid values
+	// are ('Strict', 'Update', 'Vendor'). With 'Strict' the compilation fails if the lock
+	// file is missing or if resolving the dependencies would change it, with 'Update' the
+	// lock file is updated as needed, and with 'Vendor' the dependencies must be vendored.
+	// +kubebuilder:validation:Enum=Strict;Update;Vendor
+	// +kubebuilder:default:=Update
+	// +optional
+	LockMode string `json:"lockMode,omitempty" yaml:"lockMode,omitempty"`
+	// RegistryCredentials is the list of references to Secrets in the same namespace, of
+	// type 'kubernetes.io/dockerconfigjson', holding the credentials of the OCI registries
+	// the KCL module dependencies are pulled from. For the same registry, the credentials
+	// of later Secrets take precedence. The Secrets are read with the service account the
+	// KCLRun impersonates, if any.
+	// +optional
+	RegistryCredentials []meta.LocalObjectReference `json:"registryCredentials,omitempty" yaml:"registryCredentials,omitempty"`
+}
+
+// ModuleMirror contains the OCI registry mirroring the KCL module registry.
+type ModuleMirror struct {
+	// Registry is the hostname, with an optional port, of the mirror, e.g. 'registry.local:5000',
+	// the dependencies without an explicit source are pulled from.
+	// +kubebuilder:validation:MinLength=1
+	// +optional
+	Registry string `json:"registry,omitempty" yaml:"registry,omitempty"`
+	// Repository is the repository of the modules in the mirror, e.g. 'kcl-lang'.
+	// +optional
+	Repository string `json:"repository,omitempty" yaml:"repository,omitempty"`
+	// PlainHTTP pulls the modules from the mirror over plain HTTP.
+	// +optional
+	PlainHTTP bool `json:"plainHTTP,omitempty" yaml:"plainHTTP,omitempty"`
+	// Rewrites is the list of rules rewriting the OCI and Git sources of the
+	// dependencies declared in kcl.mod and kcl.mod.lock, the first matching rule
+	// applies. The rules also apply to the registry and the repository of the
+	// dependencies without an explicit source.
+	// +optional
+	Rewrites []ModuleRewrite `json:"rewrites,omitempty" yaml:"rewrites,omitempty"`
+}
+
+// ModuleRewrite rewrites the dependency sources starting with a prefix.
+type ModuleRewrite struct {
+	// From is the prefix of the sources to rewrite, without the URL scheme and
+	// matched on path segments, e.g. 'ghcr.io/kcl-lang' or 'github.com/org'.
+	// +kubebuilder:validation:MinLength=1
+	// +required
+	From string `json:"from" yaml:"from"`
+	// To replaces the From prefix, e.g. 'registry.local:5000/kcl-lang'.
+	// +kubebuilder:validation:MinLength=1
+	// +required
+	To string `json:"to" yaml:"to"`
 }
 
 // ArgumentReference contains a reference to a resource containing the KCL compile config.
+// +kubebuilder:validation:XValidation:rule="self.kind != 'VaultSecret' || has(self.vault)",message="vault must be set for the VaultSecret kind"
 type ArgumentReference struct {
-	// Kind of the values referent, valid values are ('Secret', 'ConfigMap').
-	// +kubebuilder:validation:Enum=Secret;ConfigMap
+	// Kind of the values referent, valid values are ('Secret', 'ConfigMap', 'VaultSecret').
+	// +kubebuilder:validation:Enum=Secret;ConfigMap;VaultSecret
 	// +required
 	Kind string `json:"kind" yaml:"kind"`
 	// Name of the values referent. Should reside in the same namespace as the
-	// referring resource.
+	// referring resource. For the VaultSecret kind, it is the path of the
+	// secret in the Vault KV secrets engine, e.g. 'apps/db'.
 	// +kubebuilder:validation:MinLength=1
 	// +kubebuilder:validation:MaxLength=253
 	// +required
@@ -206,6 +616,73 @@ type ArgumentReference struct {
 	// +kubebuilder:default:=false
 	// +optional
 	Optional bool `json:"optional,omitempty" yaml:"optional,omitempty"`
+	// ValuesKey is the data key of the referent to read a single argument from,
+	// the key is used as the argument name unless TargetPath is specified.
+	// When not specified, every data key of the referent is used as an argument.
+	// +kubebuilder:validation:MaxLength=253
+	// +kubebuilder:validation:Pattern=`^[\-._a-zA-Z0-9]+$`
+	// +optional
+	ValuesKey string `json:"valuesKey,omitempty" yaml:"valuesKey,omitempty"`
+	// TargetPath is the dot separated path, e.g. 'app.image', under which the
+	// value of ValuesKey, or a dict of all the data keys when ValuesKey is not
+	// specified, is injected. The first segment is the top level argument name.
+	// +kubebuilder:validation:MaxLength=250
+	// +kubebuilder:validation:Pattern=`^[a-zA-Z_][a-zA-Z0-9_]*(\.[a-zA-Z0-9_\-]+)*$`
+	// +optional
+	TargetPath string `json:"targetPath,omitempty" yaml:"targetPath,omitempty"`
+	// Format of the referenced values, valid values are ('raw', 'json', 'yaml').
+	// With 'raw' the value is passed verbatim as a KCL string, with 'json' and
+	// 'yaml' the value is decoded into the matching KCL type, e.g. a dict or a list.
+	// When not specified, new lines are removed and KCL interprets the value.
+	// +kubebuilder:validation:Enum=raw;json;yaml
+	// +optional
+	Format string `json:"format,omitempty" yaml:"format,omitempty"`
+	// Vault holds the configuration of the Vault server the VaultSecret is read from.
+	// +optional
+	Vault *VaultReference `json:"vault,omitempty" yaml:"vault,omitempty"`
+}
+
+// VaultReference contains the Vault server and the Kubernetes auth role used to
+// read a secret of a KV secrets engine. The controller logs in with a short-lived
+// token of the service account the KCLRun is reconciled with, issued for the Audience.
+// The token is requested by impersonating the service account, which must be allowed
+// to create the 'serviceaccounts/token' subresource of itself.
+// +kubebuilder:validation:XValidation:rule="self.address.startsWith('https://') || (has(self.insecure) && self.insecure)",message="an http address requires insecure to be set"
+type VaultReference struct {
+	// Address of the Vault server, e.g. 'https://vault.vault.svc:8200'.
+	// +kubebuilder:validation:Pattern="^https?://.+$"
+	// +required
+	Address string `json:"address" yaml:"address"`
+	// Insecure allows an 'http://' Address, over which the login token and the
+	// secret are sent in cleartext. Defaults to false.
+	// +optional
+	Insecure bool `json:"insecure,omitempty" yaml:"insecure,omitempty"`
+	// Role is the Vault Kubernetes auth role bound to the service account.
+	// The role must bind the Audience with its 'audience' parameter.
+	// +kubebuilder:validation:MinLength=1
+	// +required
+	Role string `json:"role" yaml:"role"`
+	// Audience is the audience of the service account token used to log in
+	// to Vault. Defaults to 'vault'.
+	// +kubebuilder:validation:MinLength=1
+	// +kubebuilder:default:=vault
+	// +optional
+	Audience string `json:"audience,omitempty" yaml:"audience,omitempty"`
+	// AuthMount is the mount path of the Vault Kubernetes auth method.
+	// Defaults to 'kubernetes'.
+	// +kubebuilder:default:=kubernetes
+	// +optional
+	AuthMount string `json:"authMount,omitempty" yaml:"authMount,omitempty"`
+	// Mount is the mount path of the KV secrets engine. Defaults to 'secret'.
+	// +kubebuilder:default:=secret
+	// +optional
+	Mount string `json:"mount,omitempty" yaml:"mount,omitempty"`
+	// KVVersion is the version of the KV secrets engine, valid values are (1, 2).
+	// Defaults to 2.
+	// +kubebuilder:validation:Enum=1;2
+	// +kubebuilder:default:=2
+	// +optional
+	KVVersion int `json:"kvVersion,omitempty" yaml:"kvVersion,omitempty"`
 }
 
 // KCLRunStatus defines the observed state of KCLRun
@@ -224,6 +701,11 @@ type KCLRunStatus struct {
 	// +optional
 	LastAppliedRevision string `json:"lastAppliedRevision,omitempty" yaml:"lastAppliedRevision,omitempty"`
 
+	// LastAppliedArgumentsChecksum is the checksum of the arguments resolved
+	// from the ArgumentsReferences at the last successful apply.
+	// +optional
+	LastAppliedArgumentsChecksum string `json:"lastAppliedArgumentsChecksum,omitempty" yaml:"lastAppliedArgumentsChecksum,omitempty"`
+
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 
@@ -232,7 +714,7 @@ type KCLRunStatus struct {
 	LastAttemptedRevision string `json:"lastAttemptedRevision,omitempty" yaml:"lastAttemptedRevision,omitempty"`
 
 	// LastAttemptedRevisionDigest is the digest of the last reconciliation attempt.
-	// This is only set for OCIRepository sources.
+	// This is only set for OCIRepository and Module sources.
 	// +optional
 	LastAttemptedRevisionDigest string `json:"lastAttemptedRevisionDigest,omitempty" yaml:"lastAttemptedRevisionDigest,omitempty"`
 
@@ -240,6 +722,236 @@ type KCLRunStatus struct {
 	// have been successfully applied.
 	// +optional
 	Inventory *ResourceInventory `json:"inventory,omitempty" yaml:"inventory,omitempty"`
+
+	// LastPlan contains the changes computed by the last reconciliation in Plan mode.
+	// +optional
+	LastPlan *PlanResult `json:"lastPlan,omitempty" yaml:"lastPlan,omitempty"`
+
+	// CompileDiagnostics contains the errors reported by the KCL compiler
+	// for the last attempted revision.
+	// +kubebuilder:validation:MaxItems=10
+	// +optional
+	CompileDiagnostics []CompileDiagnostic `json:"compileDiagnostics,omitempty" yaml:"compileDiagnostics,omitempty"`
+
+	// RenderedManifests is the reference to the object holding the manifests
+	// rendered for the last attempted revision.
+	// +optional
+	RenderedManifests *RenderedManifestsReference `json:"renderedManifests,omitempty" yaml:"renderedManifests,omitempty"`
+
+	// LastPushedArtifact is the reference to the last OCI artifact holding the
+	// rendered manifests pushed to the output repository.
+	// +optional
+	LastPushedArtifact *OCIArtifactReference `json:"lastPushedArtifact,omitempty" yaml:"lastPushedArtifact,omitempty"`
+
+	// History contains the last applies, the most recent first.
+	// +kubebuilder:validation:MaxItems=10
+	// +optional
+	History []Snapshot `json:"history,omitempty" yaml:"history,omitempty"`
+
+	// ModuleHistory contains the last versions of the module source selected by
+	// the controller, the most recent first.
+	// +kubebuilder:validation:MaxItems=10
+	// +optional
+	ModuleHistory []ModuleVersion `json:"moduleHistory,omitempty" yaml:"moduleHistory,omitempty"`
+
+	// FailedModuleVersions contains the versions of the module source whose
+	// compilation or health checks failed and which were not applied since.
+	// Unlike the module history, the failed versions are never evicted.
+	// +optional
+	FailedModuleVersions []string `json:"failedModuleVersions,omitempty" yaml:"failedModuleVersions,omitempty"`
+}
+
+// MaxRenderedManifestsSize is the maximum size in bytes of the compressed
+// rendered manifests, bounded by the size of the Kubernetes objects.
+const MaxRenderedManifestsSize = 1000 * 1024
+
+// RenderedManifestsReference contains the reference to the object holding
+// the rendered manifests.
+type RenderedManifestsReference struct {
+	// Kind of the referent, valid values are ('Secret', 'ConfigMap').
+	// +required
+	Kind string `json:"kind" yaml:"kind"`
+
+	// Name of the referent, in the namespace of the KCLRun.
+	// +required
+	Name string `json:"name" yaml:"name"`
+
+	// Key is the data key of the gzip compressed manifests.
+	// +required
+	Key string `json:"key" yaml:"key"`
+
+	// Revision is the source revision the manifests were rendered from.
+	// +required
+	Revision string `json:"revision" yaml:"revision"`
+
+	// Digest is the digest of the uncompressed manifests.
+	// +required
+	Digest string `json:"digest" yaml:"digest"`
+
+	// Size is the size in bytes of the uncompressed manifests.
+	// +optional
+	Size int64 `json:"size,omitempty" yaml:"size,omitempty"`
+}
+
+// OCIArtifactReference contains the reference to a pushed OCI artifact.
+type OCIArtifactReference struct {
+	// URL is the OCI repository of the artifact.
+	// +required
+	URL string `json:"url" yaml:"url"`
+
+	// Tag of the artifact, derived from the source revision.
+	// +required
+	Tag string `json:"tag" yaml:"tag"`
+
+	// Digest is the digest of the OCI manifest of the artifact.
+	// +required
+	Digest string `json:"digest" yaml:"digest"`
+
+	// Revision is the source revision the manifests were rendered from.
+	// +required
+	Revision string `json:"revision" yaml:"revision"`
+
+	// ContentDigest is the digest of the pushed manifests.
+	// +required
+	ContentDigest string `json:"contentDigest" yaml:"contentDigest"`
+}
+
+const (
+	// MaxHistory is the maximum number of applies kept in the KCLRun status.
+	MaxHistory = 10
+	// SucceededOutcome is the outcome of an apply whose health checks passed.
+	SucceededOutcome = "Succeeded"
+	// FailedOutcome is the outcome of an apply which failed or whose health checks failed.
+	FailedOutcome = "Failed"
+	// RolledBackOutcome is the outcome of the re-apply of the last successful
+	// manifests after a failed health check.
+	RolledBackOutcome = "RolledBack"
+)
+
+// Snapshot records an apply of the compiled manifests.
+type Snapshot struct {
+	// Revision is the revision of the applied source.
+	// +required
+	Revision string `json:"revision" yaml:"revision"`
+
+	// ConfigDigest is the digest of the path, the compile config and the
+	// arguments of the apply.
+	// +required
+	ConfigDigest string `json:"configDigest" yaml:"configDigest"`
+
+	// InventoryDigest is the digest of the inventory of the applied objects.
+	// +optional
+	InventoryDigest string `json:"inventoryDigest,omitempty" yaml:"inventoryDigest,omitempty"`
+
+	// StartedAt is the time the reconciliation of the apply started.
+	// +required
+	StartedAt metav1.Time `json:"startedAt" yaml:"startedAt"`
+
+	// FinishedAt is the time the apply and its health checks finished.
+	// +required
+	FinishedAt metav1.Time `json:"finishedAt" yaml:"finishedAt"`
+
+	// Outcome of the apply, valid values are ('Succeeded', 'Failed', 'RolledBack').
+	// +required
+	Outcome string `json:"outcome" yaml:"outcome"`
+}
+
+const (
+	// MaxModuleHistory is the maximum number of module versions kept in the KCLRun status.
+	MaxModuleHistory = 10
+	// ModuleVersionApplied is the outcome of a module version successfully applied.
+	ModuleVersionApplied = "Applied"
+	// ModuleVersionFailed is the outcome of a module version whose compilation
+	// or health checks failed.
+	ModuleVersionFailed = "Failed"
+)
+
+// ModuleVersion is a version of the module source selected by the controller.
+type ModuleVersion struct {
+	// Version is the tag of the KCL package.
+	// +required
+	Version string `json:"version" yaml:"version"`
+
+	// Digest is the manifest digest of the KCL package.
+	// +optional
+	Digest string `json:"digest,omitempty" yaml:"digest,omitempty"`
+
+	// PreviousVersion is the tag of the KCL package applied before this version.
+	// +optional
+	PreviousVersion string `json:"previousVersion,omitempty" yaml:"previousVersion,omitempty"`
+
+	// Outcome of the version, valid values are ('Applied', 'Failed').
+	// +required
+	Outcome string `json:"outcome" yaml:"outcome"`
+
+	// LastTransitionTime is the time of the outcome.
+	// +required
+	LastTransitionTime metav1.Time `json:"lastTransitionTime" yaml:"lastTransitionTime"`
+}
+
+// MaxCompileDiagnostics is the maximum number of diagnostics kept in the KCLRun status.
+const MaxCompileDiagnostics = 10
+
+// CompileDiagnostic is an error reported by the KCL compiler.
+type CompileDiagnostic struct {
+	// File is the path of the KCL file relative to the artifact root.
+	// +optional
+	File string `json:"file,omitempty" yaml:"file,omitempty"`
+
+	// Line is the line of the error in the file.
+	// +optional
+	Line int `json:"line,omitempty" yaml:"line,omitempty"`
+
+	// Column is the column of the error in the line.
+	// +optional
+	Column int `json:"column,omitempty" yaml:"column,omitempty"`
+
+	// Kind is the KCL error kind, e.g. TypeError or EvaluationError.
+	// +required
+	Kind string `json:"kind" yaml:"kind"`
+
+	// Message is the error message.
+	// +optional
+	Message string `json:"message,omitempty" yaml:"message,omitempty"`
+}
+
+// PlanResult contains the per-object change summary of a reconciliation in Plan mode.
+type PlanResult struct {
+	// Revision is the source revision the plan was computed for.
+	// +required
+	Revision string `json:"revision" yaml:"revision"`
+
+	// Created is the number of objects that would be created.
+	// +optional
+	Created int `json:"created,omitempty" yaml:"created,omitempty"`
+
+	// Configured is the number of objects that would be updated.
+	// +optional
+	Configured int `json:"configured,omitempty" yaml:"configured,omitempty"`
+
+	// Unchanged is the number of objects that are up to date.
+	// +optional
+	Unchanged int `json:"unchanged,omitempty" yaml:"unchanged,omitempty"`
+
+	// Deleted is the number of objects that would be garbage collected.
+	// +optional
+	Deleted int `json:"deleted,omitempty" yaml:"deleted,omitempty"`
+
+	// Entries contains the planned action for each object.
+	// +optional
+	Entries []PlanEntry `json:"entries,omitempty" yaml:"entries,omitempty"`
+}
+
+// PlanEntry contains the planned action for a Kubernetes object.
+type PlanEntry struct {
+	// Subject is the object ID in the format 'kind/namespace/name'.
+	// +required
+	Subject string `json:"subject" yaml:"subject"`
+
+	// Action is the change that would be made to the object,
+	// e.g. 'created', 'configured', 'unchanged', 'deleted' or 'skipped'.
+	// +required
+	Action string `json:"action" yaml:"action"`
 }
 
 //+kubebuilder:object:root=true
@@ -318,6 +1030,57 @@ func (in *KCLRun) GetDependsOn() []meta.NamespacedObjectReference {
 	return in.Spec.DependsOn
 }
 
+// IsPlanMode returns true if the KCLRun only computes changes without
+// applying them.
+func (in *KCLRun) IsPlanMode() bool {
+	return in.Spec.Mode == PlanMode
+}
+
+// GetOutputKind returns the kind of the object the rendered manifests are
+// stored in, or the default of Secret.
+func (in *KCLRun) GetOutputKind() string {
+	if in.Spec.Output == nil || in.Spec.Output.Kind == "" {
+		return "Secret"
+	}
+	return in.Spec.Output.Kind
+}
+
+// IsRollbackEnabled returns true if the last successfully applied manifests
+// are re-applied when the health checks fail.
+func (in *KCLRun) IsRollbackEnabled() bool {
+	return in.Spec.Rollback != nil && in.Spec.Rollback.Enable
+}
+
+// GetRefusedModuleVersions returns the module versions which failed and must
+// not be selected again, if the module source refuses failed versions.
+func (in *KCLRun) GetRefusedModuleVersions() []string {
+	if in.Spec.Module == nil || !in.Spec.Module.RefuseFailedVersions {
+		return nil
+	}
+	versions := slices.Clone(in.Status.FailedModuleVersions)
+	// Refuse the versions whose last outcome in the module history is a failure,
+	// which may not be in the failed versions of a status recorded before them.
+	seen := make(map[string]bool)
+	for _, v := range in.Status.ModuleHistory {
+		if seen[v.Version] {
+			continue
+		}
+		seen[v.Version] = true
+		if v.Outcome == ModuleVersionFailed && !slices.Contains(versions, v.Version) {
+			versions = append(versions, v.Version)
+		}
+	}
+	return versions
+}
+
+// GetLockMode returns the configured kcl.mod.lock mode, or the default of Update.
+func (in *KCLRun) GetLockMode() string {
+	if in.Spec.Config == nil || in.Spec.Config.LockMode == "" {
+		return UpdateLockMode
+	}
+	return in.Spec.Config.LockMode
+}
+
 // UsePersistentClient returns the configured PersistentClient, or the default
 // of true.
 func (in *KCLRun) UsePersistentClient() bool {
@@ -40,6 +40,9 @@ func TestKCLRunDeserialize(t *testing.T) {
 	assert.NotNil(t, kclRun.Spec.ArgumentsReferences)
 	assert.Equal(t, "ConfigMap", kclRun.Spec.ArgumentsReferences[0].Kind)
 	assert.Equal(t, "config-map-reference", kclRun.Spec.ArgumentsReferences[0].Name)
+	assert.Equal(t, "ca.crt", kclRun.Spec.ArgumentsReferences[1].ValuesKey)
+	assert.Equal(t, RawArgumentFormat, kclRun.Spec.ArgumentsReferences[1].Format)
+	assert.True(t, kclRun.IsPlanMode())
 }
 
 func TestKCLRunConditions(t *testing.T) {
@@ -53,3 +56,26 @@ func TestKCLRunConditions(t *testing.T) {
 	)
 	assert.True(t, conditions.IsTrue(obj, meta.ReadyCondition))
 }
+
+func TestKCLRunRefusedModuleVersions(t *testing.T) {
+	obj := &KCLRun{
+		Spec: KCLRunSpec{
+			Module: &ModuleSource{URL: "oci://ghcr.io/kcl-lang/app", SemVer: ">=1.4.0 <2.0.0"},
+		},
+		Status: KCLRunStatus{
+			ModuleHistory: []ModuleVersion{
+				{Version: "1.6.0", Outcome: ModuleVersionFailed},
+				{Version: "1.5.0", Outcome: ModuleVersionApplied},
+				{Version: "1.4.2", Outcome: ModuleVersionFailed},
+			},
+		},
+	}
+	assert.Empty(t, obj.GetRefusedModuleVersions())
+
+	obj.Spec.Module.RefuseFailedVersions = true
+	assert.Equal(t, []string{"1.6.0", "1.4.2"}, obj.GetRefusedModuleVersions())
+
+	// The failed versions evicted from the module history are still refused.
+	obj.Status.FailedModuleVersions = []string{"1.4.2", "1.3.0"}
+	assert.Equal(t, []string{"1.4.2", "1.3.0", "1.6.0"}, obj.GetRefusedModuleVersions())
+}
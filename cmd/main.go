@@ -21,11 +21,13 @@ import (
 	"time"
 
 	flag "github.com/spf13/pflag"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	ctrl "sigs.k8s.io/controller-runtime"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 
 	"github.com/fluxcd/cli-utils/pkg/kstatus/polling"
@@ -40,6 +42,7 @@ import (
 	helper "github.com/fluxcd/pkg/runtime/controller"
 	krmkcldevfluxcdv1alpha1 "github.com/kcl-lang/flux-kcl-controller/api/v1alpha1"
 	"github.com/kcl-lang/flux-kcl-controller/internal/controller"
+	"github.com/kcl-lang/flux-kcl-controller/internal/kcl"
 	"github.com/kcl-lang/flux-kcl-controller/internal/statusreaders"
 	// +kubebuilder:scaffold:imports
 )
@@ -55,7 +58,7 @@ func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 	// GitRepository
 	utilruntime.Must(sourcev1.AddToScheme(scheme))
-	// OCIRepository
+	// OCIRepository and Bucket
 	utilruntime.Must(sourcev1beta2.AddToScheme(scheme))
 	// KCLRun
 	utilruntime.Must(krmkcldevfluxcdv1alpha1.AddToScheme(scheme))
@@ -63,12 +66,27 @@ func init() {
 }
 
 func main() {
+	// Serve the compilation requests of the controller when running as a sandbox.
+	if len(os.Args) > 1 && os.Args[1] == kcl.SandboxCommand {
+		os.Exit(kcl.RunSandbox(os.Stdin, os.Stdout, os.Stderr))
+	}
+
 	var (
 		metricsAddr           string
 		eventsAddr            string
 		requeueDependency     time.Duration
 		enableLeaderElection  bool
 		httpRetry             int
+		compileCacheSize      int64
+		artifactCacheDir      string
+		artifactCacheSize     int64
+		compileSandbox        bool
+		compileMaxMemory      int64
+		compileMaxCPUTime     time.Duration
+		moduleRegistry        string
+		moduleRepository      string
+		modulePlainHTTP       bool
+		moduleRewrites        []string
 		defaultServiceAccount string
 		logOptions            logger.Options
 
@@ -87,6 +105,29 @@ func main() {
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
 	flag.IntVar(&httpRetry, "http-retry", 9, "The maximum number of retries when failing to fetch artifacts over HTTP.")
+	flag.Int64Var(&compileCacheSize, "compile-cache-size", 64<<20,
+		"The maximum size in bytes of the in-memory cache of compiled manifests, set to 0 to disable the cache.")
+	flag.StringVar(&artifactCacheDir, "artifact-cache-dir", "",
+		"The directory in which the artifact store shared by KCLRuns referencing the same source revision creates its kcl-artifact-store subdirectory, "+
+			"the store is disabled when empty. The directory needs up to artifact-cache-size bytes of free disk space, "+
+			"and should be on the file system of the KCLRun working directories so that the artifacts are hard linked rather than copied.")
+	flag.Int64Var(&artifactCacheSize, "artifact-cache-size", 1<<30,
+		"The maximum size in bytes of the extracted artifacts kept in the artifact store, set to 0 to disable the store.")
+	flag.BoolVar(&compileSandbox, "compile-sandbox", true,
+		"Run the KCL compilation in a subprocess that is killed on timeout and bounded by the compile limits. "+
+			"When disabled, the compilations which time out keep running in the controller.")
+	flag.Int64Var(&compileMaxMemory, "compile-max-memory", 0,
+		"The maximum memory in bytes of the sandboxed KCL compilation, set to 0 for no limit.")
+	flag.DurationVar(&compileMaxCPUTime, "compile-max-cpu-time", 0,
+		"The maximum CPU time of the sandboxed KCL compilation, set to 0 for no limit.")
+	flag.StringVar(&moduleRegistry, "module-registry", "",
+		"The OCI registry mirror the KCL module dependencies are resolved from, defaults to the public KCL registry.")
+	flag.StringVar(&moduleRepository, "module-repository", "",
+		"The repository of the KCL modules in the module registry mirror.")
+	flag.BoolVar(&modulePlainHTTP, "module-plain-http", false,
+		"Pull the KCL module dependencies from the module registry mirror over plain HTTP.")
+	flag.StringArrayVar(&moduleRewrites, "module-rewrite", []string{},
+		"A '<from>=<to>' rule rewriting the sources of the KCL module dependencies starting with <from>, e.g. 'ghcr.io/kcl-lang=registry.local/kcl-lang'.")
 	flag.StringVar(&defaultServiceAccount, "default-service-account", "",
 		"Default service account used for impersonation.")
 	flag.StringArrayVar(&disallowedFieldManagers, "override-manager", []string{}, "Field manager disallowed to perform changes on managed resources.")
@@ -99,6 +140,12 @@ func main() {
 
 	flag.Parse()
 	ctrl.SetLogger(logger.NewLogger(logOptions))
+
+	rewrites, err := kcl.ParseModuleRewrites(moduleRewrites)
+	if err != nil {
+		setupLog.Error(err, "invalid module rewrites")
+		os.Exit(1)
+	}
 	ctx := ctrl.SetupSignalHandler()
 
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
@@ -107,6 +154,13 @@ func main() {
 		LeaderElection:   enableLeaderElection,
 		LeaderElectionID: "kcl-lang.io",
 		Logger:           ctrl.Log,
+		Client: ctrlclient.Options{
+			Cache: &ctrlclient.CacheOptions{
+				// Read the ConfigMaps and Secrets from the API server, so that
+				// only their metadata is cached by the watches of the controller.
+				DisableFor: []ctrlclient.Object{&corev1.ConfigMap{}, &corev1.Secret{}},
+			},
+		},
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
@@ -139,6 +193,16 @@ func main() {
 	}).SetupWithManager(ctx, mgr, controller.KCLRunReconcilerOptions{
 		DependencyRequeueInterval: requeueDependency,
 		HTTPRetry:                 httpRetry,
+		CompileCacheSize:          compileCacheSize,
+		ArtifactCacheDir:          artifactCacheDir,
+		ArtifactCacheSize:         artifactCacheSize,
+		CompileSandbox:            compileSandbox,
+		CompileMaxMemory:          compileMaxMemory,
+		CompileMaxCPUTime:         compileMaxCPUTime,
+		ModuleRegistry:            moduleRegistry,
+		ModuleRepository:          moduleRepository,
+		ModulePlainHTTP:           modulePlainHTTP,
+		ModuleRewrites:            rewrites,
 	}); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "KCLRun")
 		os.Exit(1)
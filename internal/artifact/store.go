@@ -0,0 +1,291 @@
+/*
+Copyright 2024 The KCL authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package artifact
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// StoreDirName is the name of the directory owned by the store in the
+// directory it is configured with.
+const StoreDirName = "kcl-artifact-store"
+
+// Fetcher downloads and extracts the artifact at the given URL into dir,
+// verifying its digest.
+type Fetcher interface {
+	Fetch(archiveURL, digest, dir string) error
+}
+
+// Store is a local digest-addressed store of extracted source artifacts.
+// Concurrent requests for the same digest share a single download, and
+// unreferenced artifacts are evicted in least recently used order once the
+// total size of the store exceeds its limit.
+type Store struct {
+	dir     string
+	maxSize int64
+	fetcher Fetcher
+
+	mu      sync.Mutex
+	size    int64
+	lru     *list.List
+	entries map[string]*entry
+}
+
+type entry struct {
+	digest string
+	path   string
+	size   int64
+	refs   int
+	ready  chan struct{}
+	err    error
+	elem   *list.Element
+}
+
+// NewStore returns a Store keeping at most maxSize bytes of extracted
+// artifacts in the StoreDirName subdirectory of dir, using fetcher to
+// download missing artifacts. The artifacts left in the subdirectory by a
+// previous run are removed, the rest of dir is left untouched.
+func NewStore(dir string, maxSize int64, fetcher Fetcher) (*Store, error) {
+	dir = filepath.Join(dir, StoreDirName)
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, fmt.Errorf("failed to clean artifact store dir: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create artifact store dir: %w", err)
+	}
+	return &Store{
+		dir:     dir,
+		maxSize: maxSize,
+		fetcher: fetcher,
+		lru:     list.New(),
+		entries: map[string]*entry{},
+	}, nil
+}
+
+// Acquire returns the directory holding the extracted artifact with the given
+// digest, downloading it from archiveURL if it is not already in the store.
+// The directory must be treated as read-only and is kept in the store until
+// the returned release function is called.
+func (s *Store) Acquire(archiveURL, digest string) (string, func(), error) {
+	if digest == "" {
+		return "", nil, fmt.Errorf("artifact digest is empty")
+	}
+
+	s.mu.Lock()
+	e, ok := s.entries[digest]
+	if ok {
+		e.refs++
+		if e.elem != nil {
+			s.lru.Remove(e.elem)
+			e.elem = nil
+		}
+		s.mu.Unlock()
+
+		<-e.ready
+		if e.err != nil {
+			s.release(e)
+			return "", nil, e.err
+		}
+		return e.path, func() { s.release(e) }, nil
+	}
+
+	e = &entry{
+		digest: digest,
+		path:   filepath.Join(s.dir, strings.ReplaceAll(digest, ":", "-")),
+		refs:   1,
+		ready:  make(chan struct{}),
+	}
+	s.entries[digest] = e
+	s.mu.Unlock()
+
+	e.size, e.err = s.fetch(archiveURL, digest, e.path)
+	if e.err != nil {
+		s.mu.Lock()
+		delete(s.entries, digest)
+		s.mu.Unlock()
+		close(e.ready)
+		return "", nil, e.err
+	}
+
+	s.mu.Lock()
+	s.size += e.size
+	s.mu.Unlock()
+	close(e.ready)
+	return e.path, func() { s.release(e) }, nil
+}
+
+// Len returns the number of artifacts in the store.
+func (s *Store) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries)
+}
+
+// fetch downloads the artifact into a temporary directory before moving it
+// to path, so that a partially extracted artifact is never exposed.
+func (s *Store) fetch(archiveURL, digest, path string) (int64, error) {
+	tmpDir, err := os.MkdirTemp(s.dir, "fetch-")
+	if err != nil {
+		return 0, fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err := s.fetcher.Fetch(archiveURL, digest, tmpDir); err != nil {
+		return 0, err
+	}
+	size, err := readOnlySize(tmpDir)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmpDir, path); err != nil {
+		return 0, fmt.Errorf("failed to store artifact: %w", err)
+	}
+	return size, nil
+}
+
+// release drops a reference to the entry, and evicts unreferenced entries
+// while the store is over its size limit.
+func (s *Store) release(e *entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e.refs--
+	if e.refs > 0 || e.err != nil {
+		return
+	}
+	e.elem = s.lru.PushFront(e)
+
+	for s.size > s.maxSize {
+		oldest := s.lru.Back()
+		if oldest == nil {
+			return
+		}
+		evicted := oldest.Value.(*entry)
+		s.lru.Remove(oldest)
+		delete(s.entries, evicted.digest)
+		s.size -= evicted.size
+		_ = os.RemoveAll(evicted.path)
+	}
+}
+
+// readOnlySize makes the regular files in dir read-only, so that the hard
+// links to them cannot modify the store, and returns their total size.
+func readOnlySize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			if err := os.Chmod(path, info.Mode().Perm()&^0o222); err != nil {
+				return err
+			}
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// metadataFiles are the files of the KCL packages that are rewritten in place
+// during the compilation, and are therefore copied rather than linked.
+var metadataFiles = []string{"kcl.mod", "kcl.mod.lock"}
+
+// LinkDir reproduces the extracted artifact at src in dst. The regular files
+// are hard links to the read-only files of the store, falling back to copies
+// when dst is on another file system, except for the package metadata files
+// which are always copied. The symlinks are recreated relative to dst, and a
+// symlink resolving outside of src is an error.
+func LinkDir(src, dst string) error {
+	root, err := filepath.EvalSymlinks(src)
+	if err != nil {
+		return err
+	}
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, 0o750)
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := resolveLink(root, path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case d.Type().IsRegular():
+			if !slices.Contains(metadataFiles, d.Name()) {
+				if err := os.Link(path, target); err == nil {
+					return nil
+				}
+			}
+			return copyFile(path, target)
+		default:
+			return nil
+		}
+	})
+}
+
+// resolveLink returns the target of the symlink at path relative to its
+// directory, after checking that it resolves inside of root.
+func resolveLink(root, path string) (string, error) {
+	link, err := os.Readlink(path)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if errors.Is(err, fs.ErrNotExist) && !filepath.IsAbs(link) {
+		resolved = filepath.Join(filepath.Dir(path), link)
+	} else if err != nil {
+		return "", err
+	}
+	if rel, err := filepath.Rel(root, resolved); err != nil || !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("symlink '%s' resolves outside of the artifact", filepath.Base(path))
+	}
+	return filepath.Rel(filepath.Dir(path), resolved)
+}
+
+func copyFile(src, dst string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dst, data, info.Mode().Perm()|0o200)
+}
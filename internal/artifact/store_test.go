@@ -0,0 +1,172 @@
+/*
+Copyright 2024 The KCL authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package artifact
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeFetcher struct {
+	calls atomic.Int32
+	size  int
+	err   error
+}
+
+func (f *fakeFetcher) Fetch(_, digest, dir string) error {
+	f.calls.Add(1)
+	if f.err != nil {
+		return f.err
+	}
+	return os.WriteFile(filepath.Join(dir, "main.k"), make([]byte, f.size), 0o600)
+}
+
+func TestStoreDeduplicatesFetches(t *testing.T) {
+	fetcher := &fakeFetcher{size: 4}
+	store, err := NewStore(t.TempDir(), 100, fetcher)
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			dir, release, err := store.Acquire("http://example.com/a.tar.gz", "sha256:a")
+			assert.NoError(t, err)
+			assert.FileExists(t, filepath.Join(dir, "main.k"))
+			release()
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), fetcher.calls.Load())
+	assert.Equal(t, 1, store.Len())
+}
+
+func TestStoreEvictsUnreferenced(t *testing.T) {
+	fetcher := &fakeFetcher{size: 6}
+	store, err := NewStore(t.TempDir(), 10, fetcher)
+	require.NoError(t, err)
+
+	dirA, releaseA, err := store.Acquire("http://example.com/a.tar.gz", "sha256:a")
+	require.NoError(t, err)
+	dirB, releaseB, err := store.Acquire("http://example.com/b.tar.gz", "sha256:b")
+	require.NoError(t, err)
+
+	// Referenced artifacts are kept even when the store is over its limit.
+	releaseB()
+	assert.DirExists(t, dirA)
+	assert.NoDirExists(t, dirB)
+
+	releaseA()
+	assert.DirExists(t, dirA)
+	assert.Equal(t, 1, store.Len())
+
+	_, releaseA, err = store.Acquire("http://example.com/a.tar.gz", "sha256:a")
+	require.NoError(t, err)
+	releaseA()
+	assert.Equal(t, int32(2), fetcher.calls.Load())
+}
+
+func TestStoreFetchError(t *testing.T) {
+	fetcher := &fakeFetcher{err: errors.New("boom")}
+	store, err := NewStore(t.TempDir(), 10, fetcher)
+	require.NoError(t, err)
+
+	_, _, err = store.Acquire("http://example.com/a.tar.gz", "sha256:a")
+	assert.EqualError(t, err, "boom")
+	assert.Equal(t, 0, store.Len())
+}
+
+func TestNewStoreKeepsOtherFiles(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "other"), []byte("keep"), 0o600))
+	stale := filepath.Join(dir, StoreDirName, "sha256-stale")
+	require.NoError(t, os.MkdirAll(stale, 0o750))
+
+	store, err := NewStore(dir, 10, &fakeFetcher{size: 1})
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(dir, "other"))
+	assert.NoDirExists(t, stale)
+
+	path, release, err := store.Acquire("http://example.com/a.tar.gz", "sha256:a")
+	require.NoError(t, err)
+	defer release()
+	assert.Equal(t, filepath.Join(dir, StoreDirName, "sha256-a"), path)
+}
+
+func TestLinkDir(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStore(dir, 1<<20, &fakeFetcher{size: 5})
+	require.NoError(t, err)
+	src, release, err := store.Acquire("http://source/a.tar.gz", "sha256:a")
+	require.NoError(t, err)
+	defer release()
+	require.NoError(t, os.Chmod(src, 0o750))
+	require.NoError(t, os.MkdirAll(filepath.Join(src, "pkg"), 0o750))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "pkg", "kcl.mod"), []byte("[package]"), 0o400))
+	require.NoError(t, os.Symlink("../main.k", filepath.Join(src, "pkg", "link.k")))
+	require.NoError(t, os.Symlink(filepath.Join(src, "main.k"), filepath.Join(src, "abs.k")))
+
+	dst := filepath.Join(dir, "work")
+	require.NoError(t, LinkDir(src, dst))
+
+	info, err := os.Stat(filepath.Join(dst, "main.k"))
+	require.NoError(t, err)
+	assert.Zero(t, info.Mode().Perm()&0o222, "linked files are read-only")
+	stored, err := os.Stat(filepath.Join(src, "main.k"))
+	require.NoError(t, err)
+	assert.True(t, os.SameFile(stored, info))
+
+	info, err = os.Stat(filepath.Join(dst, "pkg", "kcl.mod"))
+	require.NoError(t, err)
+	stored, err = os.Stat(filepath.Join(src, "pkg", "kcl.mod"))
+	require.NoError(t, err)
+	assert.False(t, os.SameFile(stored, info), "metadata files are copied")
+	require.NoError(t, os.WriteFile(filepath.Join(dst, "pkg", "kcl.mod"), []byte("[dependencies]"), 0o600))
+
+	link, err := os.Readlink(filepath.Join(dst, "pkg", "link.k"))
+	require.NoError(t, err)
+	assert.Equal(t, "../main.k", link)
+	link, err = os.Readlink(filepath.Join(dst, "abs.k"))
+	require.NoError(t, err)
+	assert.Equal(t, "main.k", link)
+}
+
+func TestLinkDirEscapingSymlink(t *testing.T) {
+	for name, target := range map[string]string{
+		"relative": "../outside",
+		"absolute": "/etc/passwd",
+		"dangling": "../../missing",
+		"chained":  "self/..",
+	} {
+		t.Run(name, func(t *testing.T) {
+			src := t.TempDir()
+			require.NoError(t, os.Symlink(".", filepath.Join(src, "self")))
+			require.NoError(t, os.Symlink(target, filepath.Join(src, "link.k")))
+			err := LinkDir(src, filepath.Join(t.TempDir(), "work"))
+			require.Error(t, err)
+		})
+	}
+}
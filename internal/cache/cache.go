@@ -0,0 +1,106 @@
+/*
+Copyright 2024 The KCL authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"container/list"
+	"sync"
+)
+
+// Cache is an in-memory LRU cache of compilation results bounded by the
+// total size in bytes of the stored values. A nil Cache never stores values.
+type Cache struct {
+	mu      sync.Mutex
+	maxSize int64
+	size    int64
+	ll      *list.List
+	items   map[string]*list.Element
+}
+
+type entry struct {
+	key   string
+	value []byte
+}
+
+// New returns a Cache holding at most maxSize bytes of values.
+// It returns nil if maxSize is not positive.
+func New(maxSize int64) *Cache {
+	if maxSize <= 0 {
+		return nil
+	}
+	return &Cache{
+		maxSize: maxSize,
+		ll:      list.New(),
+		items:   map[string]*list.Element{},
+	}
+}
+
+// Get returns the value stored for the given key.
+func (c *Cache) Get(key string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(e)
+	return e.Value.(*entry).value, true
+}
+
+// Set stores the value for the given key, evicting the least recently used
+// values to stay within the size limit. Values larger than the limit are
+// not stored.
+func (c *Cache) Set(key string, value []byte) {
+	if c == nil || int64(len(value)) > c.maxSize {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.items[key]; ok {
+		c.size += int64(len(value)) - int64(len(e.Value.(*entry).value))
+		e.Value.(*entry).value = value
+		c.ll.MoveToFront(e)
+	} else {
+		c.items[key] = c.ll.PushFront(&entry{key: key, value: value})
+		c.size += int64(len(value))
+	}
+
+	for c.size > c.maxSize {
+		oldest := c.ll.Back()
+		if oldest == nil {
+			break
+		}
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*entry).key)
+		c.size -= int64(len(oldest.Value.(*entry).value))
+	}
+}
+
+// Len returns the number of stored values.
+func (c *Cache) Len() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
@@ -0,0 +1,58 @@
+/*
+Copyright 2024 The KCL authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCache(t *testing.T) {
+	c := New(10)
+	c.Set("a", []byte("aaaa"))
+	c.Set("b", []byte("bbbb"))
+
+	v, ok := c.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, []byte("aaaa"), v)
+
+	// "b" is the least recently used value and is evicted.
+	c.Set("c", []byte("cccc"))
+	_, ok = c.Get("b")
+	assert.False(t, ok)
+	assert.Equal(t, 2, c.Len())
+
+	// Values larger than the limit are never stored.
+	c.Set("d", []byte("ddddddddddd"))
+	_, ok = c.Get("d")
+	assert.False(t, ok)
+
+	// Replacing a value updates the size.
+	c.Set("a", []byte("aaaaaaa"))
+	_, ok = c.Get("c")
+	assert.False(t, ok)
+	assert.Equal(t, 1, c.Len())
+}
+
+func TestCacheDisabled(t *testing.T) {
+	c := New(0)
+	assert.Nil(t, c)
+	c.Set("a", []byte("a"))
+	_, ok := c.Get("a")
+	assert.False(t, ok)
+}
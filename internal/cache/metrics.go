@@ -0,0 +1,46 @@
+/*
+Copyright 2024 The KCL authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// CacheEventTypeHit is the event type recorded for a cache hit.
+	CacheEventTypeHit = "cache_hit"
+	// CacheEventTypeMiss is the event type recorded for a cache miss.
+	CacheEventTypeMiss = "cache_miss"
+)
+
+var cacheEventsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "gotk_kcl_compile_cache_events_total",
+		Help: "Total number of compilation cache events, partitioned by event type and KCLRun.",
+	},
+	[]string{"event_type", "name", "namespace"},
+)
+
+func init() {
+	metrics.Registry.MustRegister(cacheEventsTotal)
+}
+
+// RecordEvent records a cache event for the KCLRun with the given name and namespace.
+func RecordEvent(eventType, name, namespace string) {
+	cacheEventsTotal.WithLabelValues(eventType, name, namespace).Inc()
+}
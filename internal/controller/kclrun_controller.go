@@ -19,13 +19,16 @@ package controller
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"slices"
 	"sort"
 	"strings"
 	"time"
 
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/rest"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -54,6 +57,8 @@ import (
 	ssautil "github.com/fluxcd/pkg/ssa/utils"
 	"github.com/fluxcd/pkg/tar"
 	sw "github.com/fluxcd/source-watcher/controllers"
+	"github.com/opencontainers/go-digest"
+	authenticationv1 "k8s.io/api/authentication/v1"
 	corev1 "k8s.io/api/core/v1"
 	apimeta "k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -66,9 +71,18 @@ import (
 	sourcev1 "github.com/fluxcd/source-controller/api/v1"
 	sourcev1beta2 "github.com/fluxcd/source-controller/api/v1beta2"
 	"github.com/kcl-lang/flux-kcl-controller/api/v1alpha1"
+	"github.com/kcl-lang/flux-kcl-controller/internal/artifact"
+	"github.com/kcl-lang/flux-kcl-controller/internal/cache"
+	"github.com/kcl-lang/flux-kcl-controller/internal/decryptor"
+	"github.com/kcl-lang/flux-kcl-controller/internal/images"
 	"github.com/kcl-lang/flux-kcl-controller/internal/inventory"
 	"github.com/kcl-lang/flux-kcl-controller/internal/kcl"
+	"github.com/kcl-lang/flux-kcl-controller/internal/namespace"
+	"github.com/kcl-lang/flux-kcl-controller/internal/oci"
+	"github.com/kcl-lang/flux-kcl-controller/internal/patches"
+	"github.com/kcl-lang/flux-kcl-controller/internal/postbuild"
 	intpredicates "github.com/kcl-lang/flux-kcl-controller/internal/predicates"
+	"github.com/kcl-lang/flux-kcl-controller/internal/vault"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 )
 
@@ -88,6 +102,9 @@ type KCLRunReconciler struct {
 	DefaultServiceAccount   string
 	DisallowedFieldManagers []string
 	artifactFetcher         *fetch.ArchiveFetcher
+	artifactStore           *artifact.Store
+	compileCache            *cache.Cache
+	compileOptions          kcl.CompileOptions
 	requeueDependency       time.Duration
 
 	statusManager string
@@ -96,16 +113,65 @@ type KCLRunReconciler struct {
 type KCLRunReconcilerOptions struct {
 	DependencyRequeueInterval time.Duration
 	HTTPRetry                 int
+	// CompileCacheSize is the maximum size in bytes of the cached
+	// compilation results, the cache is disabled when it is not positive.
+	CompileCacheSize int64
+	// ArtifactCacheDir is the directory of the artifact store shared by
+	// all KCLRuns, the store is disabled when it is empty.
+	ArtifactCacheDir string
+	// ArtifactCacheSize is the maximum size in bytes of the extracted
+	// artifacts kept in the artifact store.
+	ArtifactCacheSize int64
+	// CompileSandbox runs the KCL compilation in a subprocess.
+	CompileSandbox bool
+	// CompileMaxMemory is the memory limit in bytes of the compilation
+	// subprocess, it is unlimited when zero.
+	CompileMaxMemory int64
+	// CompileMaxCPUTime is the CPU time limit of the compilation
+	// subprocess, it is unlimited when zero.
+	CompileMaxCPUTime time.Duration
+	// ModuleRegistry is the default OCI registry the KCL module
+	// dependencies are resolved from.
+	ModuleRegistry string
+	// ModuleRepository is the default repository in ModuleRegistry.
+	ModuleRepository string
+	// ModulePlainHTTP pulls the KCL module dependencies over plain HTTP.
+	ModulePlainHTTP bool
+	// ModuleRewrites are the default rules rewriting the sources of the
+	// KCL module dependencies.
+	ModuleRewrites []v1alpha1.ModuleRewrite
 }
 
+const (
+	ociRepositoryIndexKey string = ".metadata.ociRepository"
+	gitRepositoryIndexKey string = ".metadata.gitRepository"
+	bucketIndexKey        string = ".metadata.bucket"
+	configMapIndexKey     string = ".metadata.argumentsConfigMaps"
+	secretIndexKey        string = ".metadata.argumentsSecrets"
+	moduleCacheIndexKey   string = ".metadata.moduleCache"
+)
+
+// compileDiagnosticEvents is the number of KCL compile diagnostics
+// included in the CompileFailed event.
+const compileDiagnosticEvents = 3
+
+const (
+	// renderedManifestsKey is the data key of the rendered manifests,
+	// compressed with gzip.
+	renderedManifestsKey = "manifests.yaml.gz"
+	// lastAppliedManifestsKey is the Secret data key of the last successfully
+	// applied manifests, compressed with gzip.
+	lastAppliedManifestsKey = "manifests.yaml.gz"
+	// lastAppliedRevisionKey is the Secret data key of the revision of the
+	// last successfully applied manifests.
+	lastAppliedRevisionKey = "revision"
+	// lastAppliedConfigDigestKey is the Secret data key of the config digest
+	// of the last successfully applied manifests.
+	lastAppliedConfigDigestKey = "configDigest"
+)
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *KCLRunReconciler) SetupWithManager(ctx context.Context, mgr ctrl.Manager, opts KCLRunReconcilerOptions) error {
-	const (
-		ociRepositoryIndexKey string = ".metadata.ociRepository"
-		gitRepositoryIndexKey string = ".metadata.gitRepository"
-		bucketIndexKey        string = ".metadata.bucket"
-	)
-
 	// Index the KCLRun by the OCIRepository references they (may) point at.
 	if err := mgr.GetCache().IndexField(ctx, &v1alpha1.KCLRun{}, ociRepositoryIndexKey,
 		r.indexBy(sourcev1beta2.OCIRepositoryKind)); err != nil {
@@ -118,6 +184,32 @@ func (r *KCLRunReconciler) SetupWithManager(ctx context.Context, mgr ctrl.Manage
 		return fmt.Errorf("failed setting index fields: %w", err)
 	}
 
+	// Index the KCLRun by the Bucket references they (may) point at.
+	if err := mgr.GetCache().IndexField(ctx, &v1alpha1.KCLRun{}, bucketIndexKey,
+		r.indexBy(sourcev1beta2.BucketKind)); err != nil {
+		return fmt.Errorf("failed setting index fields: %w", err)
+	}
+
+	// Index the KCLRun by the OCIRepository module caches they (may) resolve dependencies from.
+	if err := mgr.GetCache().IndexField(ctx, &v1alpha1.KCLRun{}, moduleCacheIndexKey,
+		r.indexByModuleCache()); err != nil {
+		return fmt.Errorf("failed setting index fields: %w", err)
+	}
+
+	// Index the KCLRun by the ConfigMap references they (may) read arguments
+	// or substitution variables from.
+	if err := mgr.GetCache().IndexField(ctx, &v1alpha1.KCLRun{}, configMapIndexKey,
+		r.indexByArgumentsReference("ConfigMap")); err != nil {
+		return fmt.Errorf("failed setting index fields: %w", err)
+	}
+
+	// Index the KCLRun by the Secret references they (may) read arguments
+	// or substitution variables from.
+	if err := mgr.GetCache().IndexField(ctx, &v1alpha1.KCLRun{}, secretIndexKey,
+		r.indexByArgumentsReference("Secret")); err != nil {
+		return fmt.Errorf("failed setting index fields: %w", err)
+	}
+
 	// Setup the artifact fetcher
 	r.artifactFetcher = fetch.New(
 		fetch.WithRetries(opts.HTTPRetry),
@@ -125,6 +217,25 @@ func (r *KCLRunReconciler) SetupWithManager(ctx context.Context, mgr ctrl.Manage
 		fetch.WithUntar(tar.WithMaxUntarSize(tar.UnlimitedUntarSize)),
 		fetch.WithHostnameOverwrite(os.Getenv("SOURCE_CONTROLLER_LOCALHOST")),
 	)
+	if opts.ArtifactCacheDir != "" && opts.ArtifactCacheSize > 0 {
+		store, err := artifact.NewStore(opts.ArtifactCacheDir, opts.ArtifactCacheSize, r.artifactFetcher)
+		if err != nil {
+			return err
+		}
+		r.artifactStore = store
+	}
+	r.compileCache = cache.New(opts.CompileCacheSize)
+	r.compileOptions = kcl.CompileOptions{
+		Sandbox:    opts.CompileSandbox,
+		MaxMemory:  opts.CompileMaxMemory,
+		MaxCPUTime: opts.CompileMaxCPUTime,
+		Modules: kcl.ModuleOptions{
+			Registry:   opts.ModuleRegistry,
+			Repository: opts.ModuleRepository,
+			PlainHTTP:  opts.ModulePlainHTTP,
+			Rewrites:   opts.ModuleRewrites,
+		},
+	}
 	r.requeueDependency = opts.DependencyRequeueInterval
 	r.statusManager = "gotk-flux-kcl-controller"
 	// New controller
@@ -142,6 +253,25 @@ func (r *KCLRunReconciler) SetupWithManager(ctx context.Context, mgr ctrl.Manage
 			handler.EnqueueRequestsFromMapFunc(r.requestsForOCIRepositoryChange),
 			builder.WithPredicates(intpredicates.SourceRevisionChangePredicate{}),
 		).
+		Watches(
+			&sourcev1beta2.Bucket{},
+			handler.EnqueueRequestsFromMapFunc(r.requestsForBucketChange),
+			builder.WithPredicates(intpredicates.BucketRevisionChangePredicate{}),
+		).
+		// Only the metadata of the ConfigMaps and Secrets is cached, the manager
+		// client must read them uncached to not start informers of full objects.
+		Watches(
+			&corev1.ConfigMap{},
+			handler.EnqueueRequestsFromMapFunc(r.requestsForArgumentsReferenceOf(configMapIndexKey)),
+			builder.WithPredicates(predicate.ResourceVersionChangedPredicate{}),
+			builder.OnlyMetadata,
+		).
+		Watches(
+			&corev1.Secret{},
+			handler.EnqueueRequestsFromMapFunc(r.requestsForArgumentsReferenceOf(secretIndexKey)),
+			builder.WithPredicates(predicate.ResourceVersionChangedPredicate{}),
+			builder.OnlyMetadata,
+		).
 		WithOptions(controller.Options{}).
 		Complete(r)
 }
@@ -149,6 +279,8 @@ func (r *KCLRunReconciler) SetupWithManager(ctx context.Context, mgr ctrl.Manage
 //+kubebuilder:rbac:groups=krm.kcl.dev.fluxcd,resources=kclruns,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=krm.kcl.dev.fluxcd,resources=kclruns/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=krm.kcl.dev.fluxcd,resources=kclruns/finalizers,verbs=update
+//+kubebuilder:rbac:groups="",resources=configmaps;secrets,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=configmaps;secrets,verbs=create;update;patch;delete
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -216,37 +348,65 @@ func (r *KCLRunReconciler) Reconcile(ctx context.Context, req ctrl.Request) (res
 		return ctrl.Result{}, nil
 	}
 
-	source, err := r.getSource(ctx, obj)
-	if err != nil {
-		conditions.MarkFalse(obj, meta.ReadyCondition, meta.ArtifactFailedReason, "%s", err)
-		if apierrors.IsNotFound(err) {
-			msg := fmt.Sprintf("Source '%s' not found", obj.Spec.SourceRef.String())
+	var artifact *sourcev1.Artifact
+	switch {
+	case obj.Spec.Inline != nil:
+		// The inline source is revisioned by the digest of its content.
+		artifact = inlineArtifact(obj.Spec.Inline)
+	case obj.Spec.Module != nil:
+		// The module is resolved at every interval to detect new revisions.
+		module, err := r.resolveModule(ctx, obj)
+		if err != nil {
+			msg := fmt.Sprintf("KCL module '%s' resolution failed: %s", obj.Spec.Module.URL, err)
+			conditions.MarkFalse(obj, meta.ReadyCondition, meta.ArtifactFailedReason, "%s", msg)
+			log.Error(err, "unable to resolve KCL module")
+			// Retry with backoff on transient errors.
+			return ctrl.Result{}, err
+		}
+		artifact = moduleArtifact(obj.Spec.Module, module)
+		obj.Status.LastAttemptedRevisionDigest = module.Digest
+
+		// Report the selection of a new version of the module.
+		previous := moduleVersion(obj.Status.LastAppliedRevision)
+		if previous != "" && previous != module.Tag && module.Tag != "" &&
+			obj.Status.LastAttemptedRevision != artifact.Revision {
+			msg := fmt.Sprintf("Upgrading KCL module from %s to %s", previous, module.Tag)
 			log.Info(msg)
-			return ctrl.Result{RequeueAfter: obj.GetRetryInterval()}, nil
+			r.event(obj, artifact.Revision, eventv1.EventSeverityInfo, msg, nil)
 		}
+	default:
+		source, err := r.getSource(ctx, obj)
+		if err != nil {
+			conditions.MarkFalse(obj, meta.ReadyCondition, meta.ArtifactFailedReason, "%s", err)
+			if apierrors.IsNotFound(err) {
+				msg := fmt.Sprintf("Source '%s' not found", obj.Spec.SourceRef.String())
+				log.Info(msg)
+				return ctrl.Result{RequeueAfter: obj.GetRetryInterval()}, nil
+			}
 
-		if acl.IsAccessDenied(err) {
-			conditions.MarkFalse(obj, meta.ReadyCondition, apiacl.AccessDeniedReason, "%s", err)
-			log.Error(err, "Access denied to cross-namespace source")
-			r.event(obj, "unknown", eventv1.EventSeverityError, err.Error(), nil)
-			return ctrl.Result{RequeueAfter: obj.GetRetryInterval()}, nil
+			if acl.IsAccessDenied(err) {
+				conditions.MarkFalse(obj, meta.ReadyCondition, apiacl.AccessDeniedReason, "%s", err)
+				log.Error(err, "Access denied to cross-namespace source")
+				r.event(obj, "unknown", eventv1.EventSeverityError, err.Error(), nil)
+				return ctrl.Result{RequeueAfter: obj.GetRetryInterval()}, nil
+			}
+			// Retry with backoff on transient errors.
+			return ctrl.Result{}, err
 		}
-		// Retry with backoff on transient errors.
-		return ctrl.Result{}, err
-	}
-	artifact := source.GetArtifact()
+		artifact = source.GetArtifact()
 
-	// Requeue the reconciliation if the source artifact is not found.
-	if artifact == nil {
-		msg := fmt.Sprintf("Source artifact not found, retrying in %s", r.requeueDependency.String())
-		conditions.MarkFalse(obj, meta.ReadyCondition, meta.ArtifactFailedReason, "%s", msg)
-		log.Info(msg)
-		return ctrl.Result{RequeueAfter: r.requeueDependency}, nil
+		// Requeue the reconciliation if the source artifact is not found.
+		if artifact == nil {
+			msg := fmt.Sprintf("Source artifact not found, retrying in %s", r.requeueDependency.String())
+			conditions.MarkFalse(obj, meta.ReadyCondition, meta.ArtifactFailedReason, "%s", msg)
+			log.Info(msg)
+			return ctrl.Result{RequeueAfter: r.requeueDependency}, nil
+		}
 	}
 
 	// Check dependencies and requeue the reconciliation if the check fails.
 	if len(obj.Spec.DependsOn) > 0 {
-		if err := r.checkDependencies(ctx, obj, source); err != nil {
+		if err := r.checkDependencies(ctx, obj, artifact); err != nil {
 			conditions.MarkFalse(obj, meta.ReadyCondition, meta.DependencyNotReadyReason, "%s", err)
 			msg := fmt.Sprintf("Dependencies do not meet ready condition, retrying in %s", r.requeueDependency.String())
 			log.Info(msg)
@@ -273,77 +433,154 @@ func (r *KCLRunReconciler) Reconcile(ctx context.Context, req ctrl.Request) (res
 		obj.Status.Inventory.DeepCopyInto(oldInventory)
 	}
 
-	// Create tmp dir
-	tmpDir, err := os.MkdirTemp("", obj.Name)
+	dec, cleanup, err := r.getDecryptor(ctx, obj)
 	if err != nil {
-		conditions.MarkFalse(obj, meta.ReadyCondition, sourcev1.DirCreationFailedReason, err.Error())
-		return ctrl.Result{}, fmt.Errorf("failed to create temp dir, error: %w", err)
+		conditions.MarkFalse(obj, meta.ReadyCondition, v1alpha1.DecryptionFailedReason, "%s", err)
+		return ctrl.Result{}, err
 	}
-	defer os.RemoveAll(tmpDir)
-	log.Info("fetching......")
-	// Download and extract artifact
-	if err := r.artifactFetcher.Fetch(artifact.URL, artifact.Digest, tmpDir); err != nil {
-		conditions.MarkFalse(obj, meta.ReadyCondition, "failed fetch artifacts", err.Error())
-		log.Error(err, "unable to fetch artifact")
+	defer cleanup()
+	arguments, err := r.getArguments(ctx, obj, dec)
+	if err != nil {
+		conditions.MarkFalse(obj, meta.ReadyCondition, meta.ReconciliationFailedReason, "%s", err)
 		return ctrl.Result{}, err
 	}
-	// Check build path exists
-	dirPath, err := securejoin.SecureJoin(tmpDir, obj.Spec.Path)
+	argsChecksum, err := argumentsChecksum(arguments)
 	if err != nil {
-		conditions.MarkFalse(obj, meta.ReadyCondition, meta.ArtifactFailedReason, "%s", err)
+		conditions.MarkFalse(obj, meta.ReadyCondition, meta.ReconciliationFailedReason, "%s", err)
 		return ctrl.Result{}, err
 	}
-	if _, err := os.Stat(dirPath); err != nil {
-		err = fmt.Errorf("KCL package path not found: %w", err)
-		conditions.MarkFalse(obj, meta.ReadyCondition, meta.ArtifactFailedReason, "%s", err)
+	vars, err := r.getSubstituteVariables(ctx, obj)
+	if err != nil {
+		conditions.MarkFalse(obj, meta.ReadyCondition, meta.ReconciliationFailedReason, "%s", err)
 		return ctrl.Result{}, err
 	}
-	vars := make(map[string]string)
-	for _, reference := range obj.Spec.ArgumentsReferences {
-		namespacedName := types.NamespacedName{Namespace: obj.GetNamespace(), Name: reference.Name}
-		switch reference.Kind {
-		case "ConfigMap":
-			cm := &corev1.ConfigMap{}
-			if err := r.Client.Get(ctx, namespacedName, cm); err != nil {
-				if reference.Optional && apierrors.IsNotFound(err) {
-					// If optional, skip the not found error.
-					continue
-				} else {
-					return ctrl.Result{}, fmt.Errorf("config reference from 'ConfigMap/%s' error: %w", reference.Name, err)
-				}
-			}
-			for k, v := range cm.Data {
-				vars[k] = strings.ReplaceAll(v, "\n", "")
-			}
-		case "Secret":
-			secret := &corev1.Secret{}
-			if err := r.Client.Get(ctx, namespacedName, secret); err != nil {
-				if reference.Optional && apierrors.IsNotFound(err) {
-					// If optional, skip the not found error.
-					continue
-				} else {
-					return ctrl.Result{}, fmt.Errorf("config reference from 'Secret/%s' error: %w", reference.Name, err)
-				}
-			}
-			for k, v := range secret.Data {
-				vars[k] = strings.ReplaceAll(string(v), "\n", "")
-			}
-		}
+	cfgDigest, err := configDigest(obj, argsChecksum, vars)
+	if err != nil {
+		conditions.MarkFalse(obj, meta.ReadyCondition, meta.ReconciliationFailedReason, "%s", err)
+		return ctrl.Result{}, err
 	}
-	// Compile the KCL source code into the Kubernetes manifests
-	res, err := kcl.CompileKclPackage(obj, dirPath, vars)
+
+	// Do not re-apply the revision and config which were rolled back, which
+	// would fail their health checks and be rolled back again at every retry.
+	if obj.IsRollbackEnabled() && isRolledBack(obj, artifact.Revision, cfgDigest) {
+		msg := fmt.Sprintf("Health checks failed for revision %s, rolled back to revision %s, waiting for a new revision or config",
+			artifact.Revision, obj.Status.History[0].Revision)
+		log.Info(msg)
+		conditions.MarkFalse(obj, meta.ReadyCondition, meta.HealthCheckFailedReason, "%s", msg)
+		conditions.Delete(obj, meta.ReconcilingCondition)
+		obj.Status.ObservedGeneration = obj.Generation
+		return ctrl.Result{RequeueAfter: jitter.JitteredIntervalDuration(obj.GetRequeueAfter())}, nil
+	}
+
+	moduleCache, err := r.getModuleCache(ctx, obj)
 	if err != nil {
-		conditions.MarkFalse(obj, meta.ReadyCondition, "FetchFailed", err.Error())
-		log.Error(err, fmt.Sprintf("failed to compile the KCL source code path %s", dirPath))
+		conditions.MarkFalse(obj, meta.ReadyCondition, v1alpha1.DependencyResolutionFailedReason, "%s", err)
+		msg := fmt.Sprintf("%s, retrying in %s", err, r.requeueDependency.String())
+		log.Info(msg)
+		return ctrl.Result{RequeueAfter: r.requeueDependency}, nil
+	}
+
+	// The compilation results are scoped to the registry credentials they are
+	// compiled with, so that they are not shared with KCLRuns lacking them.
+	credentialsDigest, err := r.registryCredentialsDigest(ctx, obj)
+	if err != nil {
+		conditions.MarkFalse(obj, meta.ReadyCondition, v1alpha1.DependencyResolutionFailedReason, "%s", err)
 		return ctrl.Result{}, err
 	}
-	objects, err := ssautil.ReadObjects(bytes.NewReader(([]byte(res.GetRawYamlResult()))))
+
+	// Reuse the compilation result of an unchanged artifact, path, config and arguments.
+	cacheKey, err := compileCacheKey(obj, artifact, moduleCache, argsChecksum, credentialsDigest)
+	if err != nil {
+		conditions.MarkFalse(obj, meta.ReadyCondition, meta.ReconciliationFailedReason, "%s", err)
+		return ctrl.Result{}, err
+	}
+	manifests, ok := r.compileCache.Get(cacheKey)
+	if ok {
+		cache.RecordEvent(cache.CacheEventTypeHit, obj.Name, obj.Namespace)
+		log.Info("using cached compilation result")
+	} else {
+		if r.compileCache != nil {
+			cache.RecordEvent(cache.CacheEventTypeMiss, obj.Name, obj.Namespace)
+		}
+		manifests, err = r.build(ctx, obj, artifact, moduleCache, arguments)
+		if err != nil {
+			// Only the compile errors of the source code are caused by the module
+			// version, not the transient fetch or dependency resolution errors.
+			if isCompileError(err) {
+				r.recordModuleVersion(obj, artifact, v1alpha1.ModuleVersionFailed)
+			}
+			return ctrl.Result{}, err
+		}
+		r.compileCache.Set(cacheKey, manifests)
+	}
+	obj.Status.CompileDiagnostics = nil
+	// Keep the compiled manifests to verify the encrypted Secrets against.
+	compiled := manifests
+	objects, err := ssautil.ReadObjects(bytes.NewReader(manifests))
 	if err != nil {
-		conditions.MarkFalse(obj, meta.ReadyCondition, "CompileFailed", err.Error())
+		conditions.MarkFalse(obj, meta.ReadyCondition, v1alpha1.CompileFailedReason, "%s", err.Error())
 		log.Error(err, "failed to compile the yaml str into kubernetes manifests")
+		r.recordModuleVersion(obj, artifact, v1alpha1.ModuleVersionFailed)
 		return ctrl.Result{}, err
 	}
-	log.Info(fmt.Sprintf("compile result %s", res.GetRawYamlResult()))
+
+	// Decrypt the encrypted Secrets before the transformations, so that the
+	// patches, the images and the post-build variables apply to their plaintext.
+	var decrypted []*unstructured.Unstructured
+	if dec != nil {
+		for _, o := range objects {
+			if decryptor.IsEncryptedSecret(o) {
+				decrypted = append(decrypted, o)
+			}
+		}
+		if err := dec.DecryptObjects(compiled, objects); err != nil {
+			msg := fmt.Sprintf("decryption failed: %s", err)
+			conditions.MarkFalse(obj, meta.ReadyCondition, v1alpha1.DecryptionFailedReason, "%s", msg)
+			r.event(obj, artifact.Revision, eventv1.EventSeverityError, msg, nil)
+			return ctrl.Result{}, err
+		}
+	}
+
+	// Apply the patches to the compiled objects.
+	if len(obj.Spec.Patches) > 0 {
+		if err := patches.Apply(objects, obj.Spec.Patches); err != nil {
+			msg := fmt.Sprintf("failed to apply the patches: %s", err)
+			conditions.MarkFalse(obj, meta.ReadyCondition, v1alpha1.PatchFailedReason, "%s", msg)
+			r.event(obj, artifact.Revision, eventv1.EventSeverityError, msg, nil)
+			return ctrl.Result{}, err
+		}
+	}
+
+	// Override the container images of the compiled workloads.
+	if len(obj.Spec.Images) > 0 {
+		if err := images.Apply(objects, obj.Spec.Images); err != nil {
+			conditions.MarkFalse(obj, meta.ReadyCondition, meta.ReconciliationFailedReason, "%s", err)
+			return ctrl.Result{}, err
+		}
+	}
+
+	// Substitute the variables in the compiled objects.
+	if obj.Spec.PostBuild != nil {
+		if err := postbuild.Substitute(objects, vars, obj.Spec.PostBuild.Strict); err != nil {
+			msg := fmt.Sprintf("post-build failed: %s", err)
+			conditions.MarkFalse(obj, meta.ReadyCondition, v1alpha1.PostBuildFailedReason, "%s", msg)
+			r.event(obj, artifact.Revision, eventv1.EventSeverityError, msg, nil)
+			return ctrl.Result{}, err
+		}
+	}
+
+	// Render the transformed objects so that the stored, pushed and last applied
+	// manifests match the applied ones. The manifests keep the ciphertext of the
+	// decrypted Secrets when not transformed, and their values are redacted
+	// otherwise, so that their plaintext is only sent to the cluster.
+	if len(obj.Spec.Patches) > 0 || len(obj.Spec.Images) > 0 || obj.Spec.PostBuild != nil {
+		transformed, err := renderObjects(objects, decrypted)
+		if err != nil {
+			conditions.MarkFalse(obj, meta.ReadyCondition, meta.ReconciliationFailedReason, "%s", err)
+			return ctrl.Result{}, err
+		}
+		manifests = transformed
+	}
 
 	// Configure the Kubernetes client for impersonation.
 	impersonation := runtimeClient.NewImpersonator(
@@ -363,6 +600,13 @@ func (r *KCLRunReconciler) Reconcile(ctx context.Context, req ctrl.Request) (res
 		return ctrl.Result{}, fmt.Errorf("failed to build kube client: %w", err)
 	}
 
+	// Set the target namespace on the namespaced objects which do not specify one.
+	if err := namespace.SetTarget(kubeClient.RESTMapper(), objects,
+		obj.GetReleaseNamespace(), obj.Spec.StrictTargetNamespace); err != nil {
+		conditions.MarkFalse(obj, meta.ReadyCondition, v1alpha1.TargetNamespaceFailedReason, "%s", err)
+		return ctrl.Result{}, err
+	}
+
 	// Remove any stale corresponding Ready=False condition with Unknown.
 	if conditions.HasAnyReason(obj, meta.ReadyCondition, "RESTClientError") {
 		conditions.MarkUnknown(obj, meta.ReadyCondition, meta.ProgressingReason, "reconciliation in progress")
@@ -374,12 +618,50 @@ func (r *KCLRunReconciler) Reconcile(ctx context.Context, req ctrl.Request) (res
 	})
 	rm.SetOwnerLabels(objects, obj.GetName(), obj.GetNamespace())
 
+	// Compute the changes with a server-side dry-run and skip apply, prune
+	// and health checks when running in Plan mode.
+	if obj.IsPlanMode() {
+		log.Info(fmt.Sprintf("planning %s", obj.GetName()))
+		plan, err := r.plan(ctx, rm, obj, artifact.Revision, objects, oldInventory)
+		if err != nil {
+			conditions.MarkFalse(obj, v1alpha1.PlannedCondition, v1alpha1.PlanFailedReason, "%s", err)
+			conditions.MarkFalse(obj, meta.ReadyCondition, v1alpha1.PlanFailedReason, "%s", err)
+			return ctrl.Result{}, fmt.Errorf("failed to run server-side dry-run: %w", err)
+		}
+		obj.Status.LastPlan = plan
+		conditions.MarkTrue(obj, v1alpha1.PlannedCondition, v1alpha1.PlanSucceededReason,
+			"Planned revision: %s", artifact.Revision)
+		// Nothing is deployed in Plan mode, leave Ready Unknown so that the
+		// dependents are not reconciled against the planned revision.
+		conditions.MarkUnknown(obj, meta.ReadyCondition, v1alpha1.PlanSucceededReason,
+			"Planned revision: %s, changes are not applied in Plan mode", artifact.Revision)
+		conditions.Delete(obj, meta.ReconcilingCondition)
+		obj.Status.ObservedGeneration = obj.Generation
+		return ctrl.Result{RequeueAfter: jitter.JitteredIntervalDuration(obj.GetRequeueAfter())}, nil
+	}
+	conditions.Delete(obj, v1alpha1.PlannedCondition)
+
+	// Store the rendered manifests for inspection.
+	if err := r.storeRenderedManifests(ctx, obj, artifact.Revision, manifests); err != nil {
+		conditions.MarkFalse(obj, meta.ReadyCondition, meta.ReconciliationFailedReason, "%s", err)
+		return ctrl.Result{}, err
+	}
+
+	// Publish the rendered manifests to the output OCI repository.
+	if err := r.pushOutput(ctx, obj, artifact.Revision, manifests); err != nil {
+		msg := fmt.Sprintf("failed to push the rendered manifests: %s", err)
+		conditions.MarkFalse(obj, meta.ReadyCondition, v1alpha1.OutputPushFailedReason, "%s", msg)
+		r.event(obj, artifact.Revision, eventv1.EventSeverityError, msg, nil)
+		return ctrl.Result{}, err
+	}
+
 	// Apply the manifests
 	log.Info(fmt.Sprintf("applying %s", obj.GetName()))
 	// Validate and apply resources in stages.
 	drifted, changeSet, err := r.apply(ctx, rm, obj, artifact.Revision, objects)
 	if err != nil {
 		conditions.MarkFalse(obj, meta.ReadyCondition, "ApplyFailed", err.Error())
+		recordSnapshot(obj, newSnapshot(artifact.Revision, cfgDigest, nil, reconcileStart, v1alpha1.FailedOutcome))
 		err = fmt.Errorf("failed to run server-side apply: %w", err)
 		return ctrl.Result{}, err
 	}
@@ -413,7 +695,9 @@ func (r *KCLRunReconciler) Reconcile(ctx context.Context, req ctrl.Request) (res
 	}
 
 	// Run the health checks for the last applied resources.
-	isNewRevision := !source.GetArtifact().HasRevision(obj.Status.LastAppliedRevision)
+	// A change of the referenced arguments is handled like a new revision.
+	isNewRevision := !artifact.HasRevision(obj.Status.LastAppliedRevision) ||
+		argsChecksum != obj.Status.LastAppliedArgumentsChecksum
 	if err := r.checkHealth(ctx,
 		rm,
 		patcher,
@@ -423,69 +707,959 @@ func (r *KCLRunReconciler) Reconcile(ctx context.Context, req ctrl.Request) (res
 		drifted,
 		changeSet.ToObjMetadataSet()); err != nil {
 		conditions.MarkFalse(obj, meta.ReadyCondition, meta.HealthCheckFailedReason, "%s", err)
+		r.recordModuleVersion(obj, artifact, v1alpha1.ModuleVersionFailed)
+		recordSnapshot(obj, newSnapshot(artifact.Revision, cfgDigest, newInventory, reconcileStart, v1alpha1.FailedOutcome))
+
+		// Re-apply the last successful manifests if enabled.
+		if obj.IsRollbackEnabled() {
+			revision, rbErr := r.rollback(ctx, rm, kubeClient.RESTMapper(), obj, cfgDigest, reconcileStart)
+			if rbErr != nil {
+				log.Error(rbErr, "failed to roll back to the last applied manifests")
+				r.event(obj, artifact.Revision, eventv1.EventSeverityError,
+					fmt.Sprintf("Rollback to the last applied manifests failed: %s", rbErr), nil)
+			} else if revision != "" {
+				msg := fmt.Sprintf("Health checks failed for revision %s, rolled back to revision %s", artifact.Revision, revision)
+				conditions.MarkFalse(obj, meta.ReadyCondition, meta.HealthCheckFailedReason, "%s: %s", msg, err)
+				log.Info(msg)
+				r.event(obj, artifact.Revision, eventv1.EventSeverityError, msg, nil)
+			}
+		}
 		return ctrl.Result{}, err
 	}
 
+	// Store the manifests to roll back to if the health checks of a later apply fail.
+	if obj.IsRollbackEnabled() {
+		lastApplied := manifests
+		if dec != nil {
+			// Roll back to the decrypted objects, which are stored in a Secret.
+			decrypted, err := ssautil.ObjectsToYAML(objects)
+			if err != nil {
+				conditions.MarkFalse(obj, meta.ReadyCondition, meta.ReconciliationFailedReason, "%s", err)
+				return ctrl.Result{}, err
+			}
+			lastApplied = []byte(decrypted)
+		}
+		if err := r.storeLastApplied(ctx, obj, artifact.Revision, cfgDigest, lastApplied); err != nil {
+			conditions.MarkFalse(obj, meta.ReadyCondition, meta.ReconciliationFailedReason, "%s", err)
+			return ctrl.Result{}, err
+		}
+	}
+
 	log.Info(fmt.Sprintf("set last applied revision %s in status.", artifact.Revision))
 
-	// Set last applied revision.
-	obj.Status.LastAppliedRevision = artifact.Revision
+	// Set last applied revision and arguments checksum, and discard any previous plan.
+	r.recordModuleVersion(obj, artifact, v1alpha1.ModuleVersionApplied)
+	recordSnapshot(obj, newSnapshot(artifact.Revision, cfgDigest, newInventory, reconcileStart, v1alpha1.SucceededOutcome))
+	obj.Status.LastAppliedRevision = artifact.Revision
+	obj.Status.LastAppliedArgumentsChecksum = argsChecksum
+	obj.Status.LastPlan = nil
+
+	// Mark the object as ready.
+	conditions.MarkTrue(
+		obj,
+		meta.ReadyCondition,
+		meta.ReconciliationSucceededReason,
+		fmt.Sprintf("Applied revision: %s", artifact.Revision),
+	)
+
+	// Requeue the reconciliation at the specified interval.
+	return ctrl.Result{RequeueAfter: jitter.JitteredIntervalDuration(obj.GetRequeueAfter())}, nil
+}
+
+func (r *KCLRunReconciler) checkDependencies(ctx context.Context,
+	obj *v1alpha1.KCLRun,
+	artifact *sourcev1.Artifact) error {
+	for _, d := range obj.Spec.DependsOn {
+		if d.Namespace == "" {
+			d.Namespace = obj.GetNamespace()
+		}
+		dName := types.NamespacedName{
+			Namespace: d.Namespace,
+			Name:      d.Name,
+		}
+		var k v1alpha1.KCLRun
+		err := r.Get(ctx, dName, &k)
+		if err != nil {
+			return fmt.Errorf("dependency '%s' not found: %w", dName, err)
+		}
+
+		if len(k.Status.Conditions) == 0 || k.Generation != k.Status.ObservedGeneration {
+			return fmt.Errorf("dependency '%s' is not ready", dName)
+		}
+
+		if !apimeta.IsStatusConditionTrue(k.Status.Conditions, meta.ReadyCondition) {
+			return fmt.Errorf("dependency '%s' is not ready", dName)
+		}
+
+		if k.Spec.SourceRef == nil || obj.Spec.SourceRef == nil {
+			continue
+		}
+
+		srcNamespace := k.Spec.SourceRef.Namespace
+		if srcNamespace == "" {
+			srcNamespace = k.GetNamespace()
+		}
+		dSrcNamespace := obj.Spec.SourceRef.Namespace
+		if dSrcNamespace == "" {
+			dSrcNamespace = obj.GetNamespace()
+		}
+
+		if k.Spec.SourceRef.Name == obj.Spec.SourceRef.Name &&
+			srcNamespace == dSrcNamespace &&
+			k.Spec.SourceRef.Kind == obj.Spec.SourceRef.Kind &&
+			!artifact.HasRevision(k.Status.LastAppliedRevision) {
+			return fmt.Errorf("dependency '%s' revision is not up to date", dName)
+		}
+	}
+
+	return nil
+}
+
+// build downloads the artifact and compiles the KCL package at spec.path,
+// resolving its dependencies from the module cache artifact if not nil,
+// and returns the rendered YAML manifests.
+func (r *KCLRunReconciler) build(ctx context.Context, obj *v1alpha1.KCLRun, artifact, moduleCache *sourcev1.Artifact, arguments []kcl.Argument) ([]byte, error) {
+	log := ctrl.LoggerFrom(ctx)
+
+	// Create tmp dir
+	tmpDir, err := os.MkdirTemp("", obj.Name)
+	if err != nil {
+		conditions.MarkFalse(obj, meta.ReadyCondition, sourcev1.DirCreationFailedReason, err.Error())
+		return nil, fmt.Errorf("failed to create temp dir, error: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	log.Info("fetching......")
+	// Write the registry credentials scoped to this compilation
+	credentialsFile, removeCredentials, err := r.registryCredentials(ctx, obj)
+	if err != nil {
+		conditions.MarkFalse(obj, meta.ReadyCondition, v1alpha1.DependencyResolutionFailedReason, "%s", err)
+		return nil, err
+	}
+	defer removeCredentials()
+	// Download and extract artifact, pull the module, or write the inline source
+	path := obj.Spec.Path
+	switch {
+	case obj.Spec.Inline != nil:
+		path = ""
+		err = writeInlineSource(tmpDir, obj.Spec.Inline)
+	case obj.Spec.Module != nil:
+		err = kcl.PullModule(ctx, obj.Spec.Module, artifact.Digest, r.moduleOptions(obj, credentialsFile), tmpDir)
+	default:
+		err = r.fetchArtifact(artifact, tmpDir)
+	}
+	if err != nil {
+		conditions.MarkFalse(obj, meta.ReadyCondition, "failed fetch artifacts", err.Error())
+		log.Error(err, "unable to fetch artifact")
+		return nil, err
+	}
+	// Check build path exists
+	dirPath, err := securejoin.SecureJoin(tmpDir, path)
+	if err != nil {
+		conditions.MarkFalse(obj, meta.ReadyCondition, meta.ArtifactFailedReason, "%s", err)
+		return nil, err
+	}
+	if _, err := os.Stat(dirPath); err != nil {
+		err = fmt.Errorf("KCL package path not found: %w", err)
+		conditions.MarkFalse(obj, meta.ReadyCondition, meta.ArtifactFailedReason, "%s", err)
+		return nil, err
+	}
+	// Check the entry files selected by the config exist
+	if _, err := kcl.ResolveEntries(obj, dirPath); err != nil {
+		conditions.MarkFalse(obj, meta.ReadyCondition, meta.ArtifactFailedReason, "%s", err)
+		return nil, err
+	}
+	// Compile the KCL source code into the Kubernetes manifests
+	compileOpts := r.compileOptions
+	compileOpts.Modules = r.moduleOptions(obj, credentialsFile)
+	if moduleCache != nil {
+		homeDir, err := os.MkdirTemp("", obj.Name+"-modules")
+		if err != nil {
+			conditions.MarkFalse(obj, meta.ReadyCondition, sourcev1.DirCreationFailedReason, "%s", err.Error())
+			return nil, fmt.Errorf("failed to create temp dir, error: %w", err)
+		}
+		defer os.RemoveAll(homeDir)
+		if err := r.fetchArtifact(moduleCache, homeDir); err != nil {
+			conditions.MarkFalse(obj, meta.ReadyCondition, v1alpha1.DependencyResolutionFailedReason, "%s", err.Error())
+			log.Error(err, "unable to fetch module cache artifact")
+			return nil, err
+		}
+		compileOpts.Modules.HomePath = homeDir
+	}
+
+	compileCtx, cancel := context.WithTimeout(ctx, obj.GetTimeout())
+	defer cancel()
+	manifests, err := kcl.Compile(compileCtx, obj, dirPath, arguments, compileOpts)
+	if kcl.IsLockMismatchError(err) {
+		msg := fmt.Sprintf("KCL dependencies do not match kcl.mod.lock: %s", err)
+		conditions.MarkFalse(obj, meta.ReadyCondition, v1alpha1.LockMismatchReason, "%s", msg)
+		r.event(obj, artifact.Revision, eventv1.EventSeverityError, msg, nil)
+		log.Error(err, "failed to verify the KCL module dependencies")
+		return nil, err
+	}
+	if kcl.IsDependencyResolutionError(err) {
+		msg := fmt.Sprintf("KCL dependency resolution failed: %s", err)
+		conditions.MarkFalse(obj, meta.ReadyCondition, v1alpha1.DependencyResolutionFailedReason, "%s", msg)
+		r.event(obj, artifact.Revision, eventv1.EventSeverityError, msg, nil)
+		log.Error(err, "failed to resolve the KCL module dependencies")
+		return nil, err
+	}
+	if errors.Is(err, kcl.ErrCompileInProgress) {
+		msg := fmt.Sprintf("KCL compilation skipped: %s", err)
+		conditions.MarkFalse(obj, meta.ReadyCondition, v1alpha1.CompileTimeoutReason, "%s", msg)
+		log.Error(err, msg)
+		return nil, err
+	}
+	if errors.Is(err, kcl.ErrCompileTimeout) {
+		msg := fmt.Sprintf("KCL compilation timed out after %s", obj.GetTimeout().String())
+		conditions.MarkFalse(obj, meta.ReadyCondition, v1alpha1.CompileTimeoutReason, "%s", msg)
+		r.event(obj, artifact.Revision, eventv1.EventSeverityError, msg, nil)
+		log.Error(err, msg)
+		return nil, err
+	}
+	if err != nil {
+		r.recordCompileDiagnostics(obj, artifact.Revision, kcl.ParseDiagnostics(err, tmpDir))
+		log.Error(err, fmt.Sprintf("failed to compile the KCL source code path %s", dirPath))
+		return nil, &compileError{err: err}
+	}
+	return manifests, nil
+}
+
+// compileError is returned by build for the errors reported by the KCL
+// compilation of the source code, as opposed to the errors fetching the
+// source, resolving its dependencies or timing out.
+type compileError struct {
+	err error
+}
+
+func (e *compileError) Error() string {
+	return e.err.Error()
+}
+
+func (e *compileError) Unwrap() error {
+	return e.err
+}
+
+// isCompileError returns true if the error was reported by the KCL
+// compilation of the source code.
+func isCompileError(err error) bool {
+	var compileErr *compileError
+	return errors.As(err, &compileErr)
+}
+
+// recordCompileDiagnostics stores the KCL compile diagnostics in the status,
+// marks the object as not ready and emits the first diagnostics as an event.
+func (r *KCLRunReconciler) recordCompileDiagnostics(obj *v1alpha1.KCLRun, revision string, diagnostics []kcl.Diagnostic) {
+	obj.Status.CompileDiagnostics = nil
+	var lines []string
+	for i, d := range diagnostics {
+		if i < v1alpha1.MaxCompileDiagnostics {
+			obj.Status.CompileDiagnostics = append(obj.Status.CompileDiagnostics, v1alpha1.CompileDiagnostic{
+				File:    d.File,
+				Line:    d.Line,
+				Column:  d.Column,
+				Kind:    d.Kind,
+				Message: d.Message,
+			})
+		}
+		if i < compileDiagnosticEvents {
+			lines = append(lines, d.String())
+		}
+	}
+	if len(diagnostics) > compileDiagnosticEvents {
+		lines = append(lines, fmt.Sprintf("and %d more error(s)", len(diagnostics)-compileDiagnosticEvents))
+	}
+
+	msg := fmt.Sprintf("KCL compilation failed\n%s", strings.Join(lines, "\n"))
+	conditions.MarkFalse(obj, meta.ReadyCondition, v1alpha1.CompileFailedReason, "%s", msg)
+	r.event(obj, revision, eventv1.EventSeverityError, msg, nil)
+}
+
+// getModuleCache returns the artifact of the OCIRepository referenced as the
+// KCL module cache, or nil if the KCLRun does not reference one.
+func (r *KCLRunReconciler) getModuleCache(ctx context.Context, obj *v1alpha1.KCLRun) (*sourcev1.Artifact, error) {
+	if obj.Spec.Config == nil || obj.Spec.Config.ModuleCacheRef == nil {
+		return nil, nil
+	}
+	repository := &sourcev1beta2.OCIRepository{}
+	key := types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.Spec.Config.ModuleCacheRef.Name}
+	if err := r.Client.Get(ctx, key, repository); err != nil {
+		return nil, fmt.Errorf("unable to get module cache OCIRepository '%s': %w", key, err)
+	}
+	if repository.GetArtifact() == nil {
+		return nil, fmt.Errorf("module cache OCIRepository '%s' has no artifact", key)
+	}
+	return repository.GetArtifact(), nil
+}
+
+// renderedManifestsName returns the name of the object holding the rendered
+// manifests of the KCLRun.
+func renderedManifestsName(obj *v1alpha1.KCLRun) string {
+	return obj.GetName() + "-rendered"
+}
+
+// storeRenderedManifests stores the compressed manifests rendered for the
+// revision in an object owned by the KCLRun, of the output kind, and
+// references it in the status. Manifests exceeding the maximum size once
+// compressed are not stored, and the values of the Secrets are redacted
+// when stored in a ConfigMap. An existing object which is not controlled
+// by the KCLRun is never overwritten.
+func (r *KCLRunReconciler) storeRenderedManifests(ctx context.Context, obj *v1alpha1.KCLRun, revision string, manifests []byte) error {
+	log := ctrl.LoggerFrom(ctx)
+	kind := obj.GetOutputKind()
+	if kind == "ConfigMap" {
+		// The values of the Secrets are not stored in plain text in a ConfigMap.
+		var err error
+		if manifests, err = redactSecrets(manifests); err != nil {
+			return fmt.Errorf("failed to redact the Secrets of the rendered manifests: %w", err)
+		}
+	}
+	manifestsDigest := digest.SHA256.FromBytes(manifests).String()
+
+	current := obj.Status.RenderedManifests
+	if current != nil && current.Kind == kind && current.Revision == revision && current.Digest == manifestsDigest {
+		return nil
+	}
+	if current != nil && current.Kind != kind {
+		if err := r.deleteRenderedManifests(ctx, obj); err != nil {
+			return err
+		}
+	}
+
+	data, err := compressManifests(manifests)
+	if err != nil {
+		return fmt.Errorf("failed to compress the rendered manifests: %w", err)
+	}
+	if len(data) > v1alpha1.MaxRenderedManifestsSize {
+		if err := r.deleteRenderedManifests(ctx, obj); err != nil {
+			return err
+		}
+		msg := fmt.Sprintf("Rendered manifests are not stored, their compressed size of %d bytes exceeds the maximum of %d bytes",
+			len(data), v1alpha1.MaxRenderedManifestsSize)
+		log.Info(msg)
+		r.event(obj, revision, eventv1.EventSeverityInfo, msg, nil)
+		return nil
+	}
+
+	objMeta := metav1.ObjectMeta{
+		Name:      renderedManifestsName(obj),
+		Namespace: obj.GetNamespace(),
+	}
+	var output client.Object
+	var mutate controllerutil.MutateFn
+	switch kind {
+	case "ConfigMap":
+		cm := &corev1.ConfigMap{ObjectMeta: objMeta}
+		output, mutate = cm, func() error {
+			if err := checkControlledBy(obj, cm); err != nil {
+				return err
+			}
+			cm.Data = nil
+			cm.BinaryData = map[string][]byte{renderedManifestsKey: data}
+			return controllerutil.SetControllerReference(obj, cm, r.Client.Scheme())
+		}
+	default:
+		secret := &corev1.Secret{ObjectMeta: objMeta}
+		output, mutate = secret, func() error {
+			if err := checkControlledBy(obj, secret); err != nil {
+				return err
+			}
+			secret.Type = corev1.SecretTypeOpaque
+			secret.Data = map[string][]byte{renderedManifestsKey: data}
+			return controllerutil.SetControllerReference(obj, secret, r.Client.Scheme())
+		}
+	}
+	if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, output, mutate); err != nil {
+		return fmt.Errorf("failed to store the rendered manifests in '%s/%s': %w", kind, objMeta.Name, err)
+	}
+
+	obj.Status.RenderedManifests = &v1alpha1.RenderedManifestsReference{
+		Kind:     kind,
+		Name:     objMeta.Name,
+		Key:      renderedManifestsKey,
+		Revision: revision,
+		Digest:   manifestsDigest,
+		Size:     int64(len(manifests)),
+	}
+	return nil
+}
+
+// deleteRenderedManifests deletes the object referenced as holding the
+// rendered manifests in the status, and removes the reference.
+func (r *KCLRunReconciler) deleteRenderedManifests(ctx context.Context, obj *v1alpha1.KCLRun) error {
+	current := obj.Status.RenderedManifests
+	if current == nil {
+		return nil
+	}
+	objMeta := metav1.ObjectMeta{Name: current.Name, Namespace: obj.GetNamespace()}
+	var output client.Object = &corev1.Secret{ObjectMeta: objMeta}
+	if current.Kind == "ConfigMap" {
+		output = &corev1.ConfigMap{ObjectMeta: objMeta}
+	}
+	if err := r.Delete(ctx, output); client.IgnoreNotFound(err) != nil {
+		return fmt.Errorf("failed to delete the rendered manifests '%s/%s': %w", current.Kind, current.Name, err)
+	}
+	obj.Status.RenderedManifests = nil
+	return nil
+}
+
+// pushOutput pushes the manifests rendered for the revision, with the values of
+// the Secrets redacted, to the output OCI repository, unless the same manifests
+// were already pushed for the revision.
+func (r *KCLRunReconciler) pushOutput(ctx context.Context, obj *v1alpha1.KCLRun, revision string, manifests []byte) error {
+	if obj.Spec.Output == nil || obj.Spec.Output.OCI == nil {
+		obj.Status.LastPushedArtifact = nil
+		return nil
+	}
+	output := obj.Spec.Output.OCI
+	// The values of the Secrets are never pushed to the registry.
+	manifests, err := redactSecrets(manifests)
+	if err != nil {
+		return fmt.Errorf("failed to redact the Secrets of the rendered manifests: %w", err)
+	}
+	tag := oci.TagFromRevision(revision)
+	contentDigest := digest.SHA256.FromBytes(manifests).String()
+	if last := obj.Status.LastPushedArtifact; last != nil &&
+		last.URL == output.URL && last.Tag == tag && last.ContentDigest == contentDigest {
+		return nil
+	}
+
+	opts := oci.PushOptions{
+		PlainHTTP: output.PlainHTTP,
+		Revision:  revision,
+	}
+	if obj.Spec.Module != nil {
+		opts.Source = obj.Spec.Module.URL
+	}
+	if output.SecretRef != nil {
+		dir, err := os.MkdirTemp("", obj.Name+"-output")
+		if err != nil {
+			return fmt.Errorf("failed to create temp dir, error: %w", err)
+		}
+		defer os.RemoveAll(dir)
+
+		kubeClient, err := r.getNamespaceClient(ctx, obj)
+		if err != nil {
+			return err
+		}
+		secret := &corev1.Secret{}
+		key := types.NamespacedName{Namespace: obj.GetNamespace(), Name: output.SecretRef.Name}
+		if err := kubeClient.Get(ctx, key, secret); err != nil {
+			return fmt.Errorf("output credentials from 'Secret/%s' error: %w", key.Name, err)
+		}
+		data, ok := secret.Data[corev1.DockerConfigJsonKey]
+		if !ok {
+			return fmt.Errorf("output credentials from 'Secret/%s' error: key '%s' not found",
+				key.Name, corev1.DockerConfigJsonKey)
+		}
+		if opts.CredentialsFile, err = kcl.WriteCredentials(dir, data); err != nil {
+			return err
+		}
+	}
+
+	pushed, err := oci.Push(ctx, output.URL, tag, manifests, opts)
+	if err != nil {
+		return err
+	}
+	obj.Status.LastPushedArtifact = &v1alpha1.OCIArtifactReference{
+		URL:           output.URL,
+		Tag:           tag,
+		Digest:        pushed,
+		Revision:      revision,
+		ContentDigest: contentDigest,
+	}
+
+	msg := fmt.Sprintf("Pushed rendered manifests to '%s:%s'", strings.TrimPrefix(output.URL, "oci://"), tag)
+	ctrl.LoggerFrom(ctx).Info(msg, "digest", pushed)
+	r.event(obj, revision, eventv1.EventSeverityInfo, msg, nil)
+	return nil
+}
+
+// lastAppliedSecretName returns the name of the Secret holding the last
+// successfully applied manifests of the KCLRun.
+func lastAppliedSecretName(obj *v1alpha1.KCLRun) string {
+	return obj.GetName() + "-last-applied"
+}
+
+// storeLastApplied stores the compressed manifests with their revision and
+// config digest in a Secret owned by the KCLRun.
+func (r *KCLRunReconciler) storeLastApplied(ctx context.Context, obj *v1alpha1.KCLRun, revision, configDigest string, manifests []byte) error {
+	data, err := compressManifests(manifests)
+	if err != nil {
+		return fmt.Errorf("failed to compress the applied manifests: %w", err)
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      lastAppliedSecretName(obj),
+			Namespace: obj.GetNamespace(),
+		},
+	}
+	if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, secret, func() error {
+		secret.Type = corev1.SecretTypeOpaque
+		secret.Data = map[string][]byte{
+			lastAppliedManifestsKey:    data,
+			lastAppliedRevisionKey:     []byte(revision),
+			lastAppliedConfigDigestKey: []byte(configDigest),
+		}
+		return controllerutil.SetControllerReference(obj, secret, r.Client.Scheme())
+	}); err != nil {
+		return fmt.Errorf("failed to store the applied manifests in 'Secret/%s': %w", secret.Name, err)
+	}
+	return nil
+}
+
+// rollback re-applies the last successfully applied manifests, prunes the
+// objects of the failed apply which are not part of them, and returns the
+// revision rolled back to. The revision is empty if there are no manifests
+// to roll back to, or if they are the ones which failed.
+func (r *KCLRunReconciler) rollback(ctx context.Context,
+	manager *ssa.ResourceManager,
+	mapper apimeta.RESTMapper,
+	obj *v1alpha1.KCLRun,
+	failedConfigDigest string,
+	started time.Time) (string, error) {
+	secret := &corev1.Secret{}
+	key := types.NamespacedName{Namespace: obj.GetNamespace(), Name: lastAppliedSecretName(obj)}
+	if err := r.Get(ctx, key, secret); err != nil {
+		return "", client.IgnoreNotFound(err)
+	}
+	revision := string(secret.Data[lastAppliedRevisionKey])
+	cfgDigest := string(secret.Data[lastAppliedConfigDigestKey])
+	if revision == obj.Status.LastAttemptedRevision && cfgDigest == failedConfigDigest {
+		return "", nil
+	}
+
+	manifests, err := decompressManifests(secret.Data[lastAppliedManifestsKey])
+	if err != nil {
+		return "", fmt.Errorf("failed to decompress the manifests of 'Secret/%s': %w", key.Name, err)
+	}
+	objects, err := ssautil.ReadObjects(bytes.NewReader(manifests))
+	if err != nil {
+		return "", err
+	}
+	if err := namespace.SetTarget(mapper, objects, obj.GetReleaseNamespace(), obj.Spec.StrictTargetNamespace); err != nil {
+		return "", err
+	}
+	manager.SetOwnerLabels(objects, obj.GetName(), obj.GetNamespace())
+
+	_, changeSet, err := r.apply(ctx, manager, obj, revision, objects)
+	if err != nil {
+		return "", err
+	}
+	rollbackInventory := inventory.New()
+	if err := inventory.AddChangeSet(rollbackInventory, changeSet); err != nil {
+		return "", err
+	}
+	staleObjects, err := inventory.Diff(obj.Status.Inventory, rollbackInventory)
+	if err != nil {
+		return "", err
+	}
+	if _, err := r.prune(ctx, manager, obj, revision, staleObjects); err != nil {
+		return "", err
+	}
+	obj.Status.Inventory = rollbackInventory
+
+	recordSnapshot(obj, newSnapshot(revision, cfgDigest, rollbackInventory, started, v1alpha1.RolledBackOutcome))
+	return revision, nil
+}
+
+// resolveModule resolves the KCL module of the KCLRun to the digest of
+// its manifest, through the module mirror and with the registry credentials
+// the dependencies are pulled with.
+func (r *KCLRunReconciler) resolveModule(ctx context.Context, obj *v1alpha1.KCLRun) (*kcl.ResolvedModule, error) {
+	credentialsFile, removeCredentials, err := r.registryCredentials(ctx, obj)
+	if err != nil {
+		return nil, err
+	}
+	defer removeCredentials()
+	return kcl.ResolveModule(ctx, obj.Spec.Module, r.moduleOptions(obj, credentialsFile), obj.GetRefusedModuleVersions())
+}
+
+// moduleOptions returns the options the KCL modules of the KCLRun are pulled
+// with, using the registry credentials file when not empty.
+func (r *KCLRunReconciler) moduleOptions(obj *v1alpha1.KCLRun, credentialsFile string) kcl.ModuleOptions {
+	opts := r.compileOptions.Modules.WithMirror(obj)
+	if credentialsFile != "" {
+		opts.CredentialsFile = credentialsFile
+	}
+	return opts
+}
+
+// recordModuleVersion records the outcome of the module version of the
+// artifact in the module history and emits an event with the previous and
+// the new version. Nothing is recorded if the version is already applied.
+func (r *KCLRunReconciler) recordModuleVersion(obj *v1alpha1.KCLRun, artifact *sourcev1.Artifact, outcome string) {
+	if obj.Spec.Module == nil {
+		return
+	}
+	version := moduleVersion(artifact.Revision)
+	previous := moduleVersion(obj.Status.LastAppliedRevision)
+	if version == "" || version == previous {
+		return
+	}
+
+	entry := v1alpha1.ModuleVersion{
+		Version:            version,
+		Digest:             artifact.Digest,
+		PreviousVersion:    previous,
+		Outcome:            outcome,
+		LastTransitionTime: metav1.Now(),
+	}
+	history := obj.Status.ModuleHistory
+	if len(history) > 0 && history[0].Version == entry.Version && history[0].Digest == entry.Digest {
+		history = history[1:]
+	}
+	history = append([]v1alpha1.ModuleVersion{entry}, history...)
+	if len(history) > v1alpha1.MaxModuleHistory {
+		history = history[:v1alpha1.MaxModuleHistory]
+	}
+	obj.Status.ModuleHistory = history
+
+	// The failed versions are kept apart from the bounded history, so that a
+	// refused version is not selected again once evicted from the history.
+	failed := slices.DeleteFunc(obj.Status.FailedModuleVersions, func(v string) bool { return v == version })
+	if outcome == v1alpha1.ModuleVersionFailed {
+		failed = append(failed, version)
+	}
+	obj.Status.FailedModuleVersions = failed
+
+	if previous == "" {
+		return
+	}
+	switch {
+	case outcome == v1alpha1.ModuleVersionApplied:
+		r.event(obj, artifact.Revision, eventv1.EventSeverityInfo,
+			fmt.Sprintf("KCL module upgraded from %s to %s", previous, version), nil)
+	case obj.Spec.Module.RefuseFailedVersions:
+		r.event(obj, artifact.Revision, eventv1.EventSeverityError,
+			fmt.Sprintf("KCL module upgrade from %s to %s failed, version %s is refused", previous, version, version), nil)
+	default:
+		r.event(obj, artifact.Revision, eventv1.EventSeverityError,
+			fmt.Sprintf("KCL module upgrade from %s to %s failed", previous, version), nil)
+	}
+}
+
+// registryCredentials writes the registry credentials of the KCLRun into a
+// temporary config.json file, returning its path and a function removing it.
+// The path is empty if the KCLRun has no registry credentials.
+func (r *KCLRunReconciler) registryCredentials(ctx context.Context, obj *v1alpha1.KCLRun) (string, func(), error) {
+	if obj.Spec.Config == nil || len(obj.Spec.Config.RegistryCredentials) == 0 {
+		return "", func() {}, nil
+	}
+	dir, err := os.MkdirTemp("", obj.Name+"-credentials")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create temp dir, error: %w", err)
+	}
+	remove := func() { os.RemoveAll(dir) }
+	path, err := r.writeRegistryCredentials(ctx, obj, dir)
+	if err != nil {
+		remove()
+		return "", nil, err
+	}
+	return path, remove, nil
+}
+
+// writeRegistryCredentials merges the docker configs of the registry
+// credentials Secrets into a config.json file in dir, scoped to a single
+// compilation, and returns its path.
+func (r *KCLRunReconciler) writeRegistryCredentials(ctx context.Context, obj *v1alpha1.KCLRun, dir string) (string, error) {
+	configs, err := r.getRegistryCredentials(ctx, obj)
+	if err != nil {
+		return "", err
+	}
+	return kcl.WriteCredentials(dir, configs...)
+}
+
+// registryCredentialsDigest returns the digest of the docker configs of the
+// registry credentials Secrets, or an empty string if the KCLRun has none.
+func (r *KCLRunReconciler) registryCredentialsDigest(ctx context.Context, obj *v1alpha1.KCLRun) (string, error) {
+	if obj.Spec.Config == nil || len(obj.Spec.Config.RegistryCredentials) == 0 {
+		return "", nil
+	}
+	configs, err := r.getRegistryCredentials(ctx, obj)
+	if err != nil {
+		return "", err
+	}
+	return digest.SHA256.FromBytes(bytes.Join(configs, []byte("\n"))).String(), nil
+}
+
+// getRegistryCredentials returns the docker configs of the registry
+// credentials Secrets of the KCLRun. The Secrets are read under the
+// impersonation of the KCLRun service account, so that a KCLRun cannot use
+// the credentials its service account is not allowed to read.
+func (r *KCLRunReconciler) getRegistryCredentials(ctx context.Context, obj *v1alpha1.KCLRun) ([][]byte, error) {
+	kubeClient, err := r.getNamespaceClient(ctx, obj)
+	if err != nil {
+		return nil, err
+	}
+
+	var configs [][]byte
+	for _, reference := range obj.Spec.Config.RegistryCredentials {
+		secret := &corev1.Secret{}
+		key := types.NamespacedName{Namespace: obj.GetNamespace(), Name: reference.Name}
+		if err := kubeClient.Get(ctx, key, secret); err != nil {
+			return nil, fmt.Errorf("registry credentials from 'Secret/%s' error: %w", reference.Name, err)
+		}
+		data, ok := secret.Data[corev1.DockerConfigJsonKey]
+		if !ok {
+			return nil, fmt.Errorf("registry credentials from 'Secret/%s' error: key '%s' not found",
+				reference.Name, corev1.DockerConfigJsonKey)
+		}
+		configs = append(configs, data)
+	}
+	return configs, nil
+}
 
-	// Mark the object as ready.
-	conditions.MarkTrue(
-		obj,
-		meta.ReadyCondition,
-		meta.ReconciliationSucceededReason,
-		fmt.Sprintf("Applied revision: %s", artifact.Revision),
+// getNamespaceClient returns the client impersonating the service account of
+// the KCLRun, if any, to access the objects it references in its namespace. The
+// objects are in the cluster of the controller, not in the cluster targeted by
+// spec.kubeConfig.
+func (r *KCLRunReconciler) getNamespaceClient(ctx context.Context, obj *v1alpha1.KCLRun) (client.Client, error) {
+	impersonation := runtimeClient.NewImpersonator(
+		r.Client,
+		r.StatusPoller,
+		r.PollingOpts,
+		nil,
+		r.KubeConfigOpts,
+		r.DefaultServiceAccount,
+		obj.Spec.ServiceAccountName,
+		obj.GetNamespace(),
 	)
+	kubeClient, _, err := impersonation.GetClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build kube client: %w", err)
+	}
+	return kubeClient, nil
+}
 
-	// Requeue the reconciliation at the specified interval.
-	return ctrl.Result{RequeueAfter: jitter.JitteredIntervalDuration(obj.GetRequeueAfter())}, nil
+// fetchArtifact extracts the artifact into dir, linking it from the shared
+// artifact store when enabled so that KCLRuns referencing the same revision
+// download it only once.
+func (r *KCLRunReconciler) fetchArtifact(src *sourcev1.Artifact, dir string) error {
+	if r.artifactStore == nil {
+		return r.artifactFetcher.Fetch(src.URL, src.Digest, dir)
+	}
+	storeDir, release, err := r.artifactStore.Acquire(src.URL, src.Digest)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return artifact.LinkDir(storeDir, dir)
 }
 
-func (r *KCLRunReconciler) checkDependencies(ctx context.Context,
-	obj *v1alpha1.KCLRun,
-	source sourcev1.Source) error {
-	for _, d := range obj.Spec.DependsOn {
-		if d.Namespace == "" {
-			d.Namespace = obj.GetNamespace()
-		}
-		dName := types.NamespacedName{
-			Namespace: d.Namespace,
-			Name:      d.Name,
-		}
-		var k v1alpha1.KCLRun
-		err := r.Get(ctx, dName, &k)
-		if err != nil {
-			return fmt.Errorf("dependency '%s' not found: %w", dName, err)
+// getArguments resolves the KCL top level arguments from the ConfigMaps and
+// Secrets referenced in the KCLRun, later references take precedence over
+// earlier ones. When enabled, the target namespace is exposed as a well-known
+// argument which can be overridden by the references. The SOPS encrypted
+// values of the Secrets are decrypted with dec, when set.
+func (r *KCLRunReconciler) getArguments(ctx context.Context,
+	obj *v1alpha1.KCLRun, dec *decryptor.Decryptor) ([]kcl.Argument, error) {
+	arguments := kcl.NewArguments()
+	if obj.Spec.ExposeTargetNamespace {
+		arguments.Set(v1alpha1.TargetNamespaceArgument, obj.GetReleaseNamespace())
+	}
+	for _, reference := range obj.Spec.ArgumentsReferences {
+		namespacedName := types.NamespacedName{Namespace: obj.GetNamespace(), Name: reference.Name}
+		var data map[string]string
+		switch reference.Kind {
+		case "ConfigMap":
+			cm := &corev1.ConfigMap{}
+			if err := r.Client.Get(ctx, namespacedName, cm); err != nil {
+				if reference.Optional && apierrors.IsNotFound(err) {
+					// If optional, skip the not found error.
+					continue
+				} else {
+					return nil, fmt.Errorf("config reference from 'ConfigMap/%s' error: %w", reference.Name, err)
+				}
+			}
+			data = cm.Data
+		case "Secret":
+			secret := &corev1.Secret{}
+			if err := r.Client.Get(ctx, namespacedName, secret); err != nil {
+				if reference.Optional && apierrors.IsNotFound(err) {
+					// If optional, skip the not found error.
+					continue
+				} else {
+					return nil, fmt.Errorf("config reference from 'Secret/%s' error: %w", reference.Name, err)
+				}
+			}
+			data = make(map[string]string, len(secret.Data))
+			for k, v := range secret.Data {
+				if dec != nil && decryptor.IsEncryptedDocument(v) {
+					plaintext, err := dec.DecryptDocument(v)
+					if err != nil {
+						return nil, fmt.Errorf("config reference from 'Secret/%s' key '%s' error: %w",
+							reference.Name, k, err)
+					}
+					v = plaintext
+				}
+				data[k] = string(v)
+			}
+		case "VaultSecret":
+			var err error
+			data, err = r.getVaultSecret(ctx, obj, reference)
+			if err != nil {
+				if reference.Optional && vault.IsNotFound(err) {
+					// If optional, skip the not found error.
+					continue
+				}
+				return nil, fmt.Errorf("config reference from 'VaultSecret/%s' error: %w", reference.Name, err)
+			}
 		}
 
-		if len(k.Status.Conditions) == 0 || k.Generation != k.Status.ObservedGeneration {
-			return fmt.Errorf("dependency '%s' is not ready", dName)
+		var keys []string
+		if reference.ValuesKey != "" {
+			if _, ok := data[reference.ValuesKey]; !ok {
+				if reference.Optional {
+					continue
+				}
+				return nil, fmt.Errorf("config reference from '%s/%s' error: key '%s' not found",
+					reference.Kind, reference.Name, reference.ValuesKey)
+			}
+			keys = []string{reference.ValuesKey}
+		} else {
+			for k := range data {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
 		}
 
-		if !apimeta.IsStatusConditionTrue(k.Status.Conditions, meta.ReadyCondition) {
-			return fmt.Errorf("dependency '%s' is not ready", dName)
+		values := make(map[string]any, len(keys))
+		for _, k := range keys {
+			value, err := kcl.ParseArgumentValue(data[k], reference.Format)
+			if err != nil {
+				return nil, fmt.Errorf("config reference from '%s/%s' key '%s' error: %w",
+					reference.Kind, reference.Name, k, err)
+			}
+			values[k] = value
+			if reference.TargetPath == "" {
+				arguments.Set(k, value)
+			}
 		}
 
-		srcNamespace := k.Spec.SourceRef.Namespace
-		if srcNamespace == "" {
-			srcNamespace = k.GetNamespace()
-		}
-		dSrcNamespace := obj.Spec.SourceRef.Namespace
-		if dSrcNamespace == "" {
-			dSrcNamespace = obj.GetNamespace()
+		// Inject the single value, or all the values as a dict, under the target path.
+		if reference.TargetPath != "" {
+			var value any = values
+			if reference.ValuesKey != "" {
+				value = values[reference.ValuesKey]
+			}
+			if err := arguments.SetPath(reference.TargetPath, value); err != nil {
+				return nil, fmt.Errorf("config reference from '%s/%s' error: %w",
+					reference.Kind, reference.Name, err)
+			}
 		}
+	}
+	return arguments.List(), nil
+}
 
-		if k.Spec.SourceRef.Name == obj.Spec.SourceRef.Name &&
-			srcNamespace == dSrcNamespace &&
-			k.Spec.SourceRef.Kind == obj.Spec.SourceRef.Kind &&
-			!source.GetArtifact().HasRevision(k.Status.LastAppliedRevision) {
-			return fmt.Errorf("dependency '%s' revision is not up to date", dName)
+// getVaultSecret reads the data of the Vault KV secret of the reference,
+// logging in to Vault with a token of the service account the KCLRun is
+// reconciled with.
+func (r *KCLRunReconciler) getVaultSecret(ctx context.Context,
+	obj *v1alpha1.KCLRun, reference v1alpha1.ArgumentReference) (map[string]string, error) {
+	if reference.Vault == nil {
+		return nil, fmt.Errorf("the Vault configuration is missing")
+	}
+	name := obj.Spec.ServiceAccountName
+	if name == "" {
+		name = r.DefaultServiceAccount
+	}
+	if name == "" {
+		return nil, fmt.Errorf("a service account is required to log in to Vault, set spec.serviceAccountName")
+	}
+	sa := &corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: obj.GetNamespace(),
+		},
+	}
+	// Request the token as the service account itself, so that a KCLRun only
+	// gets the tokens its service account is allowed to create.
+	kubeClient, err := r.getNamespaceClient(ctx, obj)
+	if err != nil {
+		return nil, err
+	}
+	// Issue the token for the Vault audience only, so that it can't be
+	// replayed against the Kubernetes API server or other services.
+	audience := reference.Vault.Audience
+	if audience == "" {
+		audience = vault.DefaultAudience
+	}
+	expiration := int64(10 * time.Minute / time.Second)
+	tokenRequest := &authenticationv1.TokenRequest{
+		Spec: authenticationv1.TokenRequestSpec{
+			Audiences:         []string{audience},
+			ExpirationSeconds: &expiration,
+		},
+	}
+	if err := kubeClient.SubResource("token").Create(ctx, sa, tokenRequest); err != nil {
+		return nil, fmt.Errorf("failed to request a token for 'ServiceAccount/%s': %w", name, err)
+	}
+	return vault.ReadSecret(ctx, reference.Vault, reference.Name, tokenRequest.Status.Token)
+}
+
+// getSubstituteVariables resolves the post-build variables from the ConfigMaps
+// and Secrets referenced in the KCLRun, later references take precedence over
+// earlier ones and the inline variables take precedence over the references.
+func (r *KCLRunReconciler) getSubstituteVariables(ctx context.Context,
+	obj *v1alpha1.KCLRun) (map[string]string, error) {
+	if obj.Spec.PostBuild == nil {
+		return nil, nil
+	}
+	vars := make(map[string]string)
+	for _, reference := range obj.Spec.PostBuild.SubstituteFrom {
+		namespacedName := types.NamespacedName{Namespace: obj.GetNamespace(), Name: reference.Name}
+		switch reference.Kind {
+		case "ConfigMap":
+			cm := &corev1.ConfigMap{}
+			if err := r.Client.Get(ctx, namespacedName, cm); err != nil {
+				if reference.Optional && apierrors.IsNotFound(err) {
+					continue
+				}
+				return nil, fmt.Errorf("substitute from 'ConfigMap/%s' error: %w", reference.Name, err)
+			}
+			for k, v := range cm.Data {
+				vars[k] = v
+			}
+		case "Secret":
+			secret := &corev1.Secret{}
+			if err := r.Client.Get(ctx, namespacedName, secret); err != nil {
+				if reference.Optional && apierrors.IsNotFound(err) {
+					continue
+				}
+				return nil, fmt.Errorf("substitute from 'Secret/%s' error: %w", reference.Name, err)
+			}
+			for k, v := range secret.Data {
+				vars[k] = string(v)
+			}
 		}
 	}
+	for k, v := range obj.Spec.PostBuild.Substitute {
+		vars[k] = v
+	}
+	if err := postbuild.ValidateVariables(vars); err != nil {
+		return nil, err
+	}
+	return vars, nil
+}
 
-	return nil
+// getDecryptor returns the decryptor using the keys of the decryption Secret
+// of the KCLRun, or nil if the decryption is not configured, and a function
+// releasing the keys of the decryptor.
+func (r *KCLRunReconciler) getDecryptor(ctx context.Context,
+	obj *v1alpha1.KCLRun) (*decryptor.Decryptor, func(), error) {
+	if obj.Spec.Decryption == nil || obj.Spec.Decryption.SecretRef == nil {
+		return nil, func() {}, nil
+	}
+	secret := &corev1.Secret{}
+	namespacedName := types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.Spec.Decryption.SecretRef.Name}
+	if err := r.Client.Get(ctx, namespacedName, secret); err != nil {
+		return nil, nil, fmt.Errorf("decryption secret '%s' error: %w", namespacedName, err)
+	}
+	dec, cleanup, err := decryptor.New(secret.Data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("decryption secret '%s' error: %w", namespacedName, err)
+	}
+	return dec, cleanup, nil
 }
 
 func (r *KCLRunReconciler) getSource(ctx context.Context,
@@ -521,6 +1695,16 @@ func (r *KCLRunReconciler) getSource(ctx context.Context,
 			return src, fmt.Errorf("unable to get source '%s': %w", namespacedName, err)
 		}
 		src = &repository
+	case sourcev1beta2.BucketKind:
+		var bucket sourcev1beta2.Bucket
+		err := r.Client.Get(ctx, namespacedName, &bucket)
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				return src, err
+			}
+			return src, fmt.Errorf("unable to get source '%s': %w", namespacedName, err)
+		}
+		src = &bucket
 	default:
 		return src, fmt.Errorf("source `%s` kind '%s' not supported",
 			obj.Spec.SourceRef.Name, obj.Spec.SourceRef.Kind)
@@ -552,6 +1736,9 @@ func (r *KCLRunReconciler) requestsForRevisionChangeOf() handler.MapFunc {
 
 		var reqs []reconcile.Request
 		for i, d := range list.Items {
+			if d.Spec.SourceRef == nil {
+				continue
+			}
 			log.Info(fmt.Sprintf("d: %v\n", d))
 			// If the KCL source is ready and the revision of the artifact equals
 			// to the last attempted revision, we should not make a request for this Kustomization
@@ -589,7 +1776,7 @@ func (r *KCLRunReconciler) requestsForOCIRepositoryChange(ctx context.Context, o
 
 	var list v1alpha1.KCLRunList
 	if err := r.List(ctx, &list, client.MatchingFields{
-		".metadata.source": client.ObjectKeyFromObject(or).String(),
+		ociRepositoryIndexKey: client.ObjectKeyFromObject(or).String(),
 	}); err != nil {
 		ctrl.LoggerFrom(ctx).Error(err, "failed to list HelmReleases for OCIRepository change")
 		return nil
@@ -612,9 +1799,75 @@ func (r *KCLRunReconciler) requestsForOCIRepositoryChange(ctx context.Context, o
 
 		reqs = append(reqs, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&list.Items[i])})
 	}
+
+	// Recompile the KCLRuns resolving their dependencies from the OCIRepository.
+	var moduleCacheList v1alpha1.KCLRunList
+	if err := r.List(ctx, &moduleCacheList, client.MatchingFields{
+		moduleCacheIndexKey: client.ObjectKeyFromObject(or).String(),
+	}); err != nil {
+		ctrl.LoggerFrom(ctx).Error(err, "failed to list KCLRuns for module cache change")
+		return reqs
+	}
+	for i := range moduleCacheList.Items {
+		req := reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&moduleCacheList.Items[i])}
+		if !slices.Contains(reqs, req) {
+			reqs = append(reqs, req)
+		}
+	}
+	return reqs
+}
+
+func (r *KCLRunReconciler) requestsForBucketChange(ctx context.Context, o client.Object) []reconcile.Request {
+	bucket, ok := o.(*sourcev1beta2.Bucket)
+	if !ok {
+		err := fmt.Errorf("expected a Bucket, got %T", o)
+		ctrl.LoggerFrom(ctx).Error(err, "failed to get requests for Bucket change")
+		return nil
+	}
+	// If we do not have an artifact, we have no requests to make
+	if bucket.GetArtifact() == nil {
+		return nil
+	}
+
+	var list v1alpha1.KCLRunList
+	if err := r.List(ctx, &list, client.MatchingFields{
+		bucketIndexKey: client.ObjectKeyFromObject(bucket).String(),
+	}); err != nil {
+		ctrl.LoggerFrom(ctx).Error(err, "failed to list KCLRuns for Bucket change")
+		return nil
+	}
+
+	var reqs []reconcile.Request
+	for i, d := range list.Items {
+		// If the KCLRun is ready and the revision of the artifact equals
+		// to the last attempted revision, we should not make a request for this KCLRun
+		if conditions.IsReady(&list.Items[i]) &&
+			bucket.GetArtifact().HasRevision(d.Status.LastAttemptedRevision) {
+			continue
+		}
+		reqs = append(reqs, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&list.Items[i])})
+	}
 	return reqs
 }
 
+func (r *KCLRunReconciler) requestsForArgumentsReferenceOf(indexKey string) handler.MapFunc {
+	return func(ctx context.Context, obj client.Object) []reconcile.Request {
+		var list v1alpha1.KCLRunList
+		if err := r.List(ctx, &list, client.MatchingFields{
+			indexKey: client.ObjectKeyFromObject(obj).String(),
+		}); err != nil {
+			ctrl.LoggerFrom(ctx).Error(err, "failed to list KCLRuns for arguments reference change")
+			return nil
+		}
+
+		reqs := make([]reconcile.Request, len(list.Items))
+		for i := range list.Items {
+			reqs[i].NamespacedName = client.ObjectKeyFromObject(&list.Items[i])
+		}
+		return reqs
+	}
+}
+
 func (r *KCLRunReconciler) checkHealth(ctx context.Context,
 	manager *ssa.ResourceManager,
 	patcher *patch.SerialPatcher,
@@ -998,6 +2251,120 @@ func (r *KCLRunReconciler) apply(ctx context.Context,
 	return applyLog != "", resultSet, nil
 }
 
+// plan performs a server-side dry-run of the given objects and returns the
+// changes that applying them, and pruning the stale inventory entries, would
+// make to the cluster.
+func (r *KCLRunReconciler) plan(ctx context.Context,
+	manager *ssa.ResourceManager,
+	obj *v1alpha1.KCLRun,
+	revision string,
+	objects []*unstructured.Unstructured,
+	oldInventory *v1alpha1.ResourceInventory) (*v1alpha1.PlanResult, error) {
+	if err := normalize.UnstructuredList(objects); err != nil {
+		return nil, err
+	}
+
+	if cmeta := obj.Spec.CommonMetadata; cmeta != nil {
+		ssautil.SetCommonMetadata(objects, cmeta.Labels, cmeta.Annotations)
+	}
+
+	diffOpts := ssa.DefaultDiffOptions()
+	diffOpts.Exclusions = map[string]string{
+		fmt.Sprintf("%s/reconcile", v1alpha1.GroupVersion.Group): v1alpha1.DisabledValue,
+		fmt.Sprintf("%s/ssa", v1alpha1.GroupVersion.Group):       v1alpha1.IgnoreValue,
+	}
+
+	changeSet := ssa.NewChangeSet()
+	namespaces, kinds := plannedDefinitions(objects)
+	sort.Sort(ssa.SortableUnstructureds(objects))
+	for _, u := range objects {
+		entry, _, _, err := manager.Diff(ctx, u, diffOpts)
+		if err != nil {
+			// The objects of a Namespace or of a custom resource kind defined by
+			// the plan can't be dry-run applied before the definition exists.
+			if !dependsOnPlanned(u, err, namespaces, kinds) {
+				return nil, err
+			}
+			entry = &ssa.ChangeSetEntry{
+				ObjMetadata:  object.UnstructuredToObjMetadata(u),
+				GroupVersion: u.GroupVersionKind().GroupVersion().String(),
+				Subject:      ssautil.FmtUnstructured(u),
+				Action:       ssa.CreatedAction,
+			}
+		}
+		changeSet.Add(*entry)
+	}
+
+	if obj.Spec.Prune {
+		newInventory := inventory.New()
+		if err := inventory.AddChangeSet(newInventory, changeSet); err != nil {
+			return nil, err
+		}
+		staleObjects, err := inventory.Diff(oldInventory, newInventory)
+		if err != nil {
+			return nil, err
+		}
+
+		inclusions := labels.SelectorFromSet(manager.GetOwnerLabels(obj.Name, obj.Namespace))
+		exclusions := map[string]string{
+			fmt.Sprintf("%s/prune", v1alpha1.GroupVersion.Group):     v1alpha1.DisabledValue,
+			fmt.Sprintf("%s/reconcile", v1alpha1.GroupVersion.Group): v1alpha1.DisabledValue,
+		}
+		for _, u := range staleObjects {
+			existing := &unstructured.Unstructured{}
+			existing.SetGroupVersionKind(u.GroupVersionKind())
+			if err := manager.Client().Get(ctx, client.ObjectKeyFromObject(u), existing); err != nil {
+				if apierrors.IsNotFound(err) {
+					continue
+				}
+				return nil, fmt.Errorf("%s query failed: %w", ssautil.FmtUnstructured(u), err)
+			}
+
+			action := ssa.DeletedAction
+			if !inclusions.Matches(labels.Set(existing.GetLabels())) || ssautil.AnyInMetadata(existing, exclusions) {
+				action = ssa.SkippedAction
+			}
+			changeSet.Add(ssa.ChangeSetEntry{
+				ObjMetadata:  object.UnstructuredToObjMetadata(u),
+				GroupVersion: u.GroupVersionKind().GroupVersion().String(),
+				Subject:      ssautil.FmtUnstructured(u),
+				Action:       action,
+			})
+		}
+	}
+
+	result := &v1alpha1.PlanResult{Revision: revision}
+	var changeSetLog strings.Builder
+	for _, entry := range changeSet.Entries {
+		switch entry.Action {
+		case ssa.CreatedAction:
+			result.Created++
+		case ssa.ConfiguredAction:
+			result.Configured++
+		case ssa.UnchangedAction:
+			result.Unchanged++
+		case ssa.DeletedAction:
+			result.Deleted++
+		}
+		if HasChanged(entry.Action) {
+			changeSetLog.WriteString(entry.String() + "\n")
+		}
+		result.Entries = append(result.Entries, v1alpha1.PlanEntry{
+			Subject: entry.Subject,
+			Action:  entry.Action.String(),
+		})
+	}
+
+	msg := fmt.Sprintf("Plan for revision %s: %d to create, %d to configure, %d unchanged, %d to delete",
+		revision, result.Created, result.Configured, result.Unchanged, result.Deleted)
+	if changes := strings.TrimSuffix(changeSetLog.String(), "\n"); changes != "" {
+		msg = msg + "\n" + changes
+	}
+	r.event(obj, revision, eventv1.EventSeverityInfo, msg, nil)
+
+	return result, nil
+}
+
 func (r *KCLRunReconciler) finalizeStatus(ctx context.Context,
 	obj *v1alpha1.KCLRun,
 	patcher *patch.SerialPatcher) error {
@@ -1037,6 +2404,7 @@ func (r *KCLRunReconciler) patch(ctx context.Context,
 		meta.ReadyCondition,
 		meta.ReconcilingCondition,
 		meta.StalledCondition,
+		v1alpha1.PlannedCondition,
 	}
 	patchOpts = append(patchOpts,
 		patch.WithOwnedConditions{Conditions: ownedConditions},
@@ -1065,7 +2433,7 @@ func (r *KCLRunReconciler) indexBy(kind string) func(o client.Object) []string {
 			panic(fmt.Sprintf("Expected a KCLRun, got %T", o))
 		}
 
-		if k.Spec.SourceRef.Kind == kind {
+		if k.Spec.SourceRef != nil && k.Spec.SourceRef.Kind == kind {
 			namespace := k.GetNamespace()
 			if k.Spec.SourceRef.Namespace != "" {
 				namespace = k.Spec.SourceRef.Namespace
@@ -1076,3 +2444,44 @@ func (r *KCLRunReconciler) indexBy(kind string) func(o client.Object) []string {
 		return nil
 	}
 }
+
+func (r *KCLRunReconciler) indexByModuleCache() func(o client.Object) []string {
+	return func(o client.Object) []string {
+		k, ok := o.(*v1alpha1.KCLRun)
+		if !ok {
+			panic(fmt.Sprintf("Expected a KCLRun, got %T", o))
+		}
+
+		if k.Spec.Config != nil && k.Spec.Config.ModuleCacheRef != nil {
+			return []string{fmt.Sprintf("%s/%s", k.GetNamespace(), k.Spec.Config.ModuleCacheRef.Name)}
+		}
+		return nil
+	}
+}
+
+func (r *KCLRunReconciler) indexByArgumentsReference(kind string) func(o client.Object) []string {
+	return func(o client.Object) []string {
+		k, ok := o.(*v1alpha1.KCLRun)
+		if !ok {
+			panic(fmt.Sprintf("Expected a KCLRun, got %T", o))
+		}
+
+		var keys []string
+		for _, reference := range k.Spec.ArgumentsReferences {
+			if reference.Kind == kind {
+				keys = append(keys, fmt.Sprintf("%s/%s", k.GetNamespace(), reference.Name))
+			}
+		}
+		if k.Spec.PostBuild != nil {
+			for _, reference := range k.Spec.PostBuild.SubstituteFrom {
+				if reference.Kind == kind {
+					keys = append(keys, fmt.Sprintf("%s/%s", k.GetNamespace(), reference.Name))
+				}
+			}
+		}
+		if kind == "Secret" && k.Spec.Decryption != nil && k.Spec.Decryption.SecretRef != nil {
+			keys = append(keys, fmt.Sprintf("%s/%s", k.GetNamespace(), k.Spec.Decryption.SecretRef.Name))
+		}
+		return keys
+	}
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package controller
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"testing"
@@ -24,14 +25,21 @@ import (
 
 	"github.com/fluxcd/pkg/apis/meta"
 	"github.com/fluxcd/pkg/runtime/conditions"
+	"github.com/fluxcd/pkg/ssa"
+	ssautil "github.com/fluxcd/pkg/ssa/utils"
 	sourcev1 "github.com/fluxcd/source-controller/api/v1"
 	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 
 	"github.com/kcl-lang/flux-kcl-controller/api/v1alpha1"
+	"github.com/kcl-lang/flux-kcl-controller/internal/postbuild"
 )
 
 func TestKCLRunReconciler_StagedApply(t *testing.T) {
@@ -68,7 +76,7 @@ func TestKCLRunReconciler_StagedApply(t *testing.T) {
 		Interval: metav1.Duration{Duration: 10 * time.Minute},
 		Prune:    true,
 		Path:     "./testdata/crds",
-		SourceRef: v1alpha1.CrossNamespaceSourceReference{
+		SourceRef: &v1alpha1.CrossNamespaceSourceReference{
 			Name:      repositoryName.Name,
 			Namespace: repositoryName.Namespace,
 			Kind:      sourcev1.GitRepositoryKind,
@@ -97,3 +105,642 @@ func TestKCLRunReconciler_StagedApply(t *testing.T) {
 		return errors.IsNotFound(err)
 	}, timeout, time.Second).Should(BeTrue())
 }
+
+func TestKCLRunReconciler_PlanMode(t *testing.T) {
+	g := NewWithT(t)
+
+	namespaceName := "flux-kcl-" + randStringRunes(5)
+	namespace := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: namespaceName},
+	}
+	g.Expect(k8sClient.Create(ctx, namespace)).ToNot(HaveOccurred())
+	t.Cleanup(func() {
+		g.Expect(k8sClient.Delete(ctx, namespace)).NotTo(HaveOccurred())
+	})
+
+	err := createKubeConfigSecret(namespaceName)
+	g.Expect(err).NotTo(HaveOccurred(), "failed to create kubeconfig secret")
+
+	artifactName := "val-" + randStringRunes(5)
+	artifactChecksum, err := testServer.ArtifactFromDir("testdata/crds", artifactName)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	repositoryName := types.NamespacedName{
+		Name:      fmt.Sprintf("val-%s", randStringRunes(5)),
+		Namespace: namespaceName,
+	}
+
+	err = applyGitRepository(repositoryName, artifactName, "main/"+artifactChecksum)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	obj := &v1alpha1.KCLRun{}
+	obj.Name = "test-flux-kcl-plan"
+	obj.Namespace = namespaceName
+	obj.Spec = v1alpha1.KCLRunSpec{
+		Interval: metav1.Duration{Duration: 10 * time.Minute},
+		Prune:    true,
+		Mode:     v1alpha1.PlanMode,
+		Path:     "./testdata/crds",
+		SourceRef: &v1alpha1.CrossNamespaceSourceReference{
+			Name:      repositoryName.Name,
+			Namespace: repositoryName.Namespace,
+			Kind:      sourcev1.GitRepositoryKind,
+		},
+		KubeConfig: &meta.KubeConfigReference{
+			SecretRef: meta.SecretKeyReference{
+				Name: "kubeconfig",
+			},
+		},
+	}
+	key := types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetName()}
+	g.Expect(k8sClient.Create(context.Background(), obj)).To(Succeed())
+
+	resultK := &v1alpha1.KCLRun{}
+	g.Eventually(func() bool {
+		err := k8sClient.Get(context.Background(), key, resultK)
+		return err == nil && conditions.IsTrue(resultK, v1alpha1.PlannedCondition) &&
+			conditions.GetReason(resultK, v1alpha1.PlannedCondition) == v1alpha1.PlanSucceededReason
+	}, timeout, time.Second).Should(BeTrue())
+
+	// Nothing is applied nor stored in Plan mode.
+	g.Expect(conditions.IsUnknown(resultK, meta.ReadyCondition)).To(BeTrue())
+	g.Expect(conditions.Has(resultK, meta.ReconcilingCondition)).To(BeFalse())
+	g.Expect(resultK.Status.RenderedManifests).To(BeNil())
+
+	g.Expect(resultK.Status.LastPlan).ToNot(BeNil())
+	g.Expect(resultK.Status.LastPlan.Revision).To(Equal("main/" + artifactChecksum))
+	g.Expect(resultK.Status.LastPlan.Entries).ToNot(BeEmpty())
+	g.Expect(resultK.Status.LastAppliedRevision).To(BeEmpty())
+	g.Expect(resultK.Status.Inventory).To(BeNil())
+
+	g.Expect(k8sClient.Delete(context.Background(), obj)).To(Succeed())
+
+	g.Eventually(func() bool {
+		var obj v1alpha1.KCLRun
+		err := k8sClient.Get(context.Background(), key, &obj)
+		return errors.IsNotFound(err)
+	}, timeout, time.Second).Should(BeTrue())
+}
+
+func TestKCLRunReconciler_PlanNewNamespace(t *testing.T) {
+	g := NewWithT(t)
+
+	namespaceName := "flux-kcl-" + randStringRunes(5)
+	namespace := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: namespaceName},
+	}
+	g.Expect(k8sClient.Create(ctx, namespace)).ToNot(HaveOccurred())
+	t.Cleanup(func() {
+		g.Expect(k8sClient.Delete(ctx, namespace)).NotTo(HaveOccurred())
+	})
+
+	err := createKubeConfigSecret(namespaceName)
+	g.Expect(err).NotTo(HaveOccurred(), "failed to create kubeconfig secret")
+
+	// The namespaced objects are planned with their Namespace, which does not exist yet.
+	newNamespace := "flux-kcl-new-" + randStringRunes(5)
+	obj := &v1alpha1.KCLRun{}
+	obj.Name = "test-flux-kcl-plan-namespace"
+	obj.Namespace = namespaceName
+	obj.Spec = v1alpha1.KCLRunSpec{
+		Interval: metav1.Duration{Duration: 10 * time.Minute},
+		Prune:    true,
+		Mode:     v1alpha1.PlanMode,
+		Inline: &v1alpha1.InlineSource{
+			Code: `import manifests
+
+manifests.yaml_stream([
+    {apiVersion = "v1", kind = "Namespace", metadata = {name = "` + newNamespace + `"}}
+    {apiVersion = "v1", kind = "ConfigMap", metadata = {name = "app", namespace = "` + newNamespace + `"}, data = {key = "value"}}
+    {apiVersion = "v1", kind = "ServiceAccount", metadata = {name = "app", namespace = "` + newNamespace + `"}}
+])
+`,
+		},
+		KubeConfig: &meta.KubeConfigReference{
+			SecretRef: meta.SecretKeyReference{
+				Name: "kubeconfig",
+			},
+		},
+	}
+	key := types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetName()}
+	g.Expect(k8sClient.Create(context.Background(), obj)).To(Succeed())
+
+	resultK := &v1alpha1.KCLRun{}
+	g.Eventually(func() bool {
+		err := k8sClient.Get(context.Background(), key, resultK)
+		return err == nil && conditions.IsTrue(resultK, v1alpha1.PlannedCondition) &&
+			conditions.GetReason(resultK, v1alpha1.PlannedCondition) == v1alpha1.PlanSucceededReason
+	}, timeout, time.Second).Should(BeTrue())
+
+	g.Expect(resultK.Status.LastPlan).ToNot(BeNil())
+	g.Expect(resultK.Status.LastPlan.Created).To(Equal(3))
+	g.Expect(resultK.Status.LastPlan.Entries).To(ContainElements(
+		v1alpha1.PlanEntry{Subject: "Namespace/" + newNamespace, Action: string(ssa.CreatedAction)},
+		v1alpha1.PlanEntry{Subject: "ConfigMap/" + newNamespace + "/app", Action: string(ssa.CreatedAction)},
+		v1alpha1.PlanEntry{Subject: "ServiceAccount/" + newNamespace + "/app", Action: string(ssa.CreatedAction)},
+	))
+
+	// Nothing is applied in Plan mode.
+	err = k8sClient.Get(context.Background(), types.NamespacedName{Name: newNamespace}, &corev1.Namespace{})
+	g.Expect(errors.IsNotFound(err)).To(BeTrue())
+
+	g.Expect(k8sClient.Delete(context.Background(), obj)).To(Succeed())
+
+	g.Eventually(func() bool {
+		var obj v1alpha1.KCLRun
+		err := k8sClient.Get(context.Background(), key, &obj)
+		return errors.IsNotFound(err)
+	}, timeout, time.Second).Should(BeTrue())
+}
+
+func TestKCLRunReconciler_ArgumentsReferenceWatch(t *testing.T) {
+	g := NewWithT(t)
+
+	namespaceName := "flux-kcl-" + randStringRunes(5)
+	namespace := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: namespaceName},
+	}
+	g.Expect(k8sClient.Create(ctx, namespace)).ToNot(HaveOccurred())
+	t.Cleanup(func() {
+		g.Expect(k8sClient.Delete(ctx, namespace)).NotTo(HaveOccurred())
+	})
+
+	err := createKubeConfigSecret(namespaceName)
+	g.Expect(err).NotTo(HaveOccurred(), "failed to create kubeconfig secret")
+
+	artifactName := "val-" + randStringRunes(5)
+	artifactChecksum, err := testServer.ArtifactFromDir("testdata/crds", artifactName)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	repositoryName := types.NamespacedName{
+		Name:      fmt.Sprintf("val-%s", randStringRunes(5)),
+		Namespace: namespaceName,
+	}
+
+	err = applyGitRepository(repositoryName, artifactName, "main/"+artifactChecksum)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "kcl-arguments",
+			Namespace: namespaceName,
+		},
+		Data: map[string]string{
+			"env": "dev",
+		},
+	}
+	g.Expect(k8sClient.Create(ctx, cm)).To(Succeed())
+
+	obj := &v1alpha1.KCLRun{}
+	obj.Name = "test-flux-kcl-arguments"
+	obj.Namespace = namespaceName
+	obj.Spec = v1alpha1.KCLRunSpec{
+		Interval: metav1.Duration{Duration: 10 * time.Minute},
+		Prune:    true,
+		Path:     "./testdata/crds",
+		SourceRef: &v1alpha1.CrossNamespaceSourceReference{
+			Name:      repositoryName.Name,
+			Namespace: repositoryName.Namespace,
+			Kind:      sourcev1.GitRepositoryKind,
+		},
+		ArgumentsReferences: []v1alpha1.ArgumentReference{
+			{
+				Kind: "ConfigMap",
+				Name: cm.Name,
+			},
+		},
+		KubeConfig: &meta.KubeConfigReference{
+			SecretRef: meta.SecretKeyReference{
+				Name: "kubeconfig",
+			},
+		},
+	}
+	key := types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetName()}
+	g.Expect(k8sClient.Create(context.Background(), obj)).To(Succeed())
+
+	var checksum string
+	g.Eventually(func() bool {
+		var obj v1alpha1.KCLRun
+		err := k8sClient.Get(context.Background(), key, &obj)
+		checksum = obj.Status.LastAppliedArgumentsChecksum
+		return err == nil && isReconcileSuccess(&obj) && checksum != ""
+	}, timeout, time.Second).Should(BeTrue())
+
+	// Changing the ConfigMap must trigger a reconciliation well before the interval.
+	cm.Data["env"] = "prod"
+	g.Expect(k8sClient.Update(ctx, cm)).To(Succeed())
+
+	g.Eventually(func() bool {
+		var obj v1alpha1.KCLRun
+		err := k8sClient.Get(context.Background(), key, &obj)
+		return err == nil && isReconcileSuccess(&obj) &&
+			obj.Status.LastAppliedArgumentsChecksum != "" &&
+			obj.Status.LastAppliedArgumentsChecksum != checksum
+	}, timeout, time.Second).Should(BeTrue())
+
+	g.Expect(k8sClient.Delete(context.Background(), obj)).To(Succeed())
+
+	g.Eventually(func() bool {
+		var obj v1alpha1.KCLRun
+		err := k8sClient.Get(context.Background(), key, &obj)
+		return errors.IsNotFound(err)
+	}, timeout, time.Second).Should(BeTrue())
+}
+
+func TestKCLRunReconciler_InlineSource(t *testing.T) {
+	g := NewWithT(t)
+
+	namespaceName := "flux-kcl-" + randStringRunes(5)
+	namespace := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: namespaceName},
+	}
+	g.Expect(k8sClient.Create(ctx, namespace)).ToNot(HaveOccurred())
+	t.Cleanup(func() {
+		g.Expect(k8sClient.Delete(ctx, namespace)).NotTo(HaveOccurred())
+	})
+
+	err := createKubeConfigSecret(namespaceName)
+	g.Expect(err).NotTo(HaveOccurred(), "failed to create kubeconfig secret")
+
+	obj := &v1alpha1.KCLRun{}
+	obj.Name = "test-flux-kcl-inline"
+	obj.Namespace = namespaceName
+	obj.Spec = v1alpha1.KCLRunSpec{
+		Interval: metav1.Duration{Duration: 10 * time.Minute},
+		Prune:    true,
+		Inline: &v1alpha1.InlineSource{
+			Code: `apiVersion = "v1"
+kind = "ConfigMap"
+metadata = {name = "inline", namespace = "` + namespaceName + `"}
+data = {key = "value"}
+`,
+		},
+		KubeConfig: &meta.KubeConfigReference{
+			SecretRef: meta.SecretKeyReference{
+				Name: "kubeconfig",
+			},
+		},
+	}
+	revision := inlineArtifact(obj.Spec.Inline).Revision
+	key := types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetName()}
+	g.Expect(k8sClient.Create(context.Background(), obj)).To(Succeed())
+
+	g.Eventually(func() bool {
+		var obj v1alpha1.KCLRun
+		err := k8sClient.Get(context.Background(), key, &obj)
+		return err == nil && isReconcileSuccess(&obj) && obj.Status.LastAppliedRevision == revision
+	}, timeout, time.Second).Should(BeTrue())
+
+	cm := &corev1.ConfigMap{}
+	g.Expect(k8sClient.Get(context.Background(), types.NamespacedName{Namespace: namespaceName, Name: "inline"}, cm)).To(Succeed())
+	g.Expect(cm.Data).To(HaveKeyWithValue("key", "value"))
+
+	resultK := &v1alpha1.KCLRun{}
+	g.Expect(k8sClient.Get(context.Background(), key, resultK)).To(Succeed())
+	g.Expect(resultK.Status.RenderedManifests).ToNot(BeNil())
+	g.Expect(resultK.Status.RenderedManifests.Kind).To(Equal("Secret"))
+	g.Expect(resultK.Status.RenderedManifests.Revision).To(Equal(revision))
+
+	rendered := &corev1.Secret{}
+	g.Expect(k8sClient.Get(context.Background(), types.NamespacedName{
+		Namespace: namespaceName,
+		Name:      resultK.Status.RenderedManifests.Name,
+	}, rendered)).To(Succeed())
+	manifests, err := decompressManifests(rendered.Data[resultK.Status.RenderedManifests.Key])
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(string(manifests)).To(ContainSubstring("name: inline"))
+
+	g.Expect(k8sClient.Delete(context.Background(), obj)).To(Succeed())
+
+	g.Eventually(func() bool {
+		var obj v1alpha1.KCLRun
+		err := k8sClient.Get(context.Background(), key, &obj)
+		return errors.IsNotFound(err)
+	}, timeout, time.Second).Should(BeTrue())
+}
+
+func TestKCLRunReconciler_RollbackHistory(t *testing.T) {
+	g := NewWithT(t)
+
+	namespaceName := "flux-kcl-" + randStringRunes(5)
+	namespace := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: namespaceName},
+	}
+	g.Expect(k8sClient.Create(ctx, namespace)).ToNot(HaveOccurred())
+	t.Cleanup(func() {
+		g.Expect(k8sClient.Delete(ctx, namespace)).NotTo(HaveOccurred())
+	})
+
+	err := createKubeConfigSecret(namespaceName)
+	g.Expect(err).NotTo(HaveOccurred(), "failed to create kubeconfig secret")
+
+	obj := &v1alpha1.KCLRun{}
+	obj.Name = "test-flux-kcl-rollback"
+	obj.Namespace = namespaceName
+	obj.Spec = v1alpha1.KCLRunSpec{
+		Interval: metav1.Duration{Duration: 10 * time.Minute},
+		Prune:    true,
+		Inline: &v1alpha1.InlineSource{
+			Code: `apiVersion = "v1"
+kind = "ConfigMap"
+metadata = {name = "rollback", namespace = "` + namespaceName + `"}
+data = {key = "value"}
+`,
+		},
+		Rollback: &v1alpha1.RollbackSpec{Enable: true},
+		KubeConfig: &meta.KubeConfigReference{
+			SecretRef: meta.SecretKeyReference{
+				Name: "kubeconfig",
+			},
+		},
+	}
+	revision := inlineArtifact(obj.Spec.Inline).Revision
+	key := types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetName()}
+	g.Expect(k8sClient.Create(context.Background(), obj)).To(Succeed())
+
+	resultK := &v1alpha1.KCLRun{}
+	g.Eventually(func() bool {
+		err := k8sClient.Get(context.Background(), key, resultK)
+		return err == nil && isReconcileSuccess(resultK) && resultK.Status.LastAppliedRevision == revision
+	}, timeout, time.Second).Should(BeTrue())
+
+	g.Expect(resultK.Status.History).To(HaveLen(1))
+	g.Expect(resultK.Status.History[0].Revision).To(Equal(revision))
+	g.Expect(resultK.Status.History[0].Outcome).To(Equal(v1alpha1.SucceededOutcome))
+	g.Expect(resultK.Status.History[0].InventoryDigest).To(Equal(inventoryDigest(resultK.Status.Inventory)))
+
+	secret := &corev1.Secret{}
+	g.Expect(k8sClient.Get(context.Background(), types.NamespacedName{
+		Namespace: namespaceName,
+		Name:      lastAppliedSecretName(resultK),
+	}, secret)).To(Succeed())
+	g.Expect(string(secret.Data[lastAppliedRevisionKey])).To(Equal(revision))
+	g.Expect(secret.OwnerReferences).To(HaveLen(1))
+	manifests, err := decompressManifests(secret.Data[lastAppliedManifestsKey])
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(string(manifests)).To(ContainSubstring("name: rollback"))
+
+	g.Expect(k8sClient.Delete(context.Background(), obj)).To(Succeed())
+
+	g.Eventually(func() bool {
+		var obj v1alpha1.KCLRun
+		err := k8sClient.Get(context.Background(), key, &obj)
+		return errors.IsNotFound(err)
+	}, timeout, time.Second).Should(BeTrue())
+}
+
+func TestKCLRunReconciler_RollbackOnHealthCheckFailure(t *testing.T) {
+	g := NewWithT(t)
+
+	namespaceName := "flux-kcl-" + randStringRunes(5)
+	namespace := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: namespaceName},
+	}
+	g.Expect(k8sClient.Create(ctx, namespace)).ToNot(HaveOccurred())
+	t.Cleanup(func() {
+		g.Expect(k8sClient.Delete(ctx, namespace)).NotTo(HaveOccurred())
+	})
+
+	err := createKubeConfigSecret(namespaceName)
+	g.Expect(err).NotTo(HaveOccurred(), "failed to create kubeconfig secret")
+
+	obj := &v1alpha1.KCLRun{}
+	obj.Name = "test-flux-kcl-rollback-failure"
+	obj.Namespace = namespaceName
+	obj.Spec = v1alpha1.KCLRunSpec{
+		Interval: metav1.Duration{Duration: 10 * time.Minute},
+		Timeout:  &metav1.Duration{Duration: 5 * time.Second},
+		Prune:    true,
+		Wait:     true,
+		Inline: &v1alpha1.InlineSource{
+			Code: `apiVersion = "v1"
+kind = "ConfigMap"
+metadata = {name = "rollback", namespace = "` + namespaceName + `"}
+data = {key = "value"}
+`,
+		},
+		Rollback: &v1alpha1.RollbackSpec{Enable: true},
+		KubeConfig: &meta.KubeConfigReference{
+			SecretRef: meta.SecretKeyReference{
+				Name: "kubeconfig",
+			},
+		},
+	}
+	revision := inlineArtifact(obj.Spec.Inline).Revision
+	key := types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetName()}
+	g.Expect(k8sClient.Create(context.Background(), obj)).To(Succeed())
+
+	resultK := &v1alpha1.KCLRun{}
+	g.Eventually(func() bool {
+		err := k8sClient.Get(context.Background(), key, resultK)
+		return err == nil && isReconcileSuccess(resultK) && resultK.Status.LastAppliedRevision == revision
+	}, timeout, time.Second).Should(BeTrue())
+	appliedInventory := resultK.Status.Inventory.DeepCopy()
+
+	// The Deployment never becomes ready without a controller running it.
+	resultK.Spec.Inline = &v1alpha1.InlineSource{
+		Code: `apiVersion = "apps/v1"
+kind = "Deployment"
+metadata = {name = "rollback", namespace = "` + namespaceName + `"}
+spec = {
+    selector = {matchLabels = {app = "rollback"}}
+    template = {
+        metadata = {labels = {app = "rollback"}}
+        spec = {containers = [{name = "app", image = "nginx"}]}
+    }
+}
+`,
+	}
+	failedRevision := inlineArtifact(resultK.Spec.Inline).Revision
+	g.Expect(k8sClient.Update(context.Background(), resultK)).To(Succeed())
+
+	g.Eventually(func() bool {
+		err := k8sClient.Get(context.Background(), key, resultK)
+		return err == nil && len(resultK.Status.History) > 0 &&
+			resultK.Status.History[0].Outcome == v1alpha1.RolledBackOutcome
+	}, timeout, time.Second).Should(BeTrue())
+
+	g.Expect(resultK.Status.History).To(HaveLen(3))
+	g.Expect(resultK.Status.History[0].Revision).To(Equal(revision))
+	g.Expect(resultK.Status.History[0].InventoryDigest).To(Equal(inventoryDigest(appliedInventory)))
+	g.Expect(resultK.Status.History[1].Revision).To(Equal(failedRevision))
+	g.Expect(resultK.Status.History[1].Outcome).To(Equal(v1alpha1.FailedOutcome))
+	g.Expect(resultK.Status.Inventory).To(Equal(appliedInventory))
+	g.Expect(resultK.Status.LastAppliedRevision).To(Equal(revision))
+
+	deployment := &appsv1.Deployment{}
+	g.Eventually(func() bool {
+		err := k8sClient.Get(context.Background(), types.NamespacedName{Namespace: namespaceName, Name: "rollback"}, deployment)
+		return errors.IsNotFound(err)
+	}, timeout, time.Second).Should(BeTrue())
+
+	// The rolled back revision is not applied again.
+	g.Eventually(func() bool {
+		err := k8sClient.Get(context.Background(), key, resultK)
+		return err == nil && conditions.IsFalse(resultK, meta.ReadyCondition) &&
+			conditions.GetReason(resultK, meta.ReadyCondition) == meta.HealthCheckFailedReason &&
+			!conditions.Has(resultK, meta.ReconcilingCondition)
+	}, timeout, time.Second).Should(BeTrue())
+	g.Consistently(func() []v1alpha1.Snapshot {
+		g.Expect(k8sClient.Get(context.Background(), key, resultK)).To(Succeed())
+		return resultK.Status.History
+	}, 10*time.Second, time.Second).Should(HaveLen(3))
+	g.Expect(resultK.Status.History[0].Outcome).To(Equal(v1alpha1.RolledBackOutcome))
+
+	g.Expect(k8sClient.Delete(context.Background(), resultK)).To(Succeed())
+
+	g.Eventually(func() bool {
+		var obj v1alpha1.KCLRun
+		err := k8sClient.Get(context.Background(), key, &obj)
+		return errors.IsNotFound(err)
+	}, timeout, time.Second).Should(BeTrue())
+}
+
+func TestRedactSecrets(t *testing.T) {
+	g := NewWithT(t)
+
+	manifests := []byte(`apiVersion: v1
+kind: Secret
+metadata:
+  name: credentials
+data:
+  password: czNjcjN0
+stringData:
+  token: plain
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: settings
+data:
+  key: value
+`)
+	redacted, err := redactSecrets(manifests)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(string(redacted)).NotTo(ContainSubstring("czNjcjN0"))
+	g.Expect(string(redacted)).NotTo(ContainSubstring("plain"))
+	g.Expect(string(redacted)).To(ContainSubstring("password: '***'"))
+	g.Expect(string(redacted)).To(ContainSubstring("token: '***'"))
+	g.Expect(string(redacted)).To(ContainSubstring("key: value"))
+
+	manifests = []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: settings
+data:
+  key: value
+`)
+	redacted, err = redactSecrets(manifests)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(redacted).To(Equal(manifests))
+}
+
+func TestRenderObjects(t *testing.T) {
+	g := NewWithT(t)
+
+	objects, err := ssautil.ReadObjects(bytes.NewReader([]byte(`apiVersion: v1
+kind: Secret
+metadata:
+  name: decrypted
+stringData:
+  url: postgres://${db_host}:5432
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: plain
+stringData:
+  token: plain
+`)))
+	g.Expect(err).NotTo(HaveOccurred())
+
+	// The post-build variables are substituted in the decrypted values.
+	g.Expect(postbuild.Substitute(objects, map[string]string{"db_host": "db.apps"}, true)).To(Succeed())
+	rendered, err := renderObjects(objects, objects[:1])
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(string(rendered)).NotTo(ContainSubstring("db.apps"))
+	g.Expect(string(rendered)).To(ContainSubstring("url: '***'"))
+	g.Expect(string(rendered)).To(ContainSubstring("token: plain"))
+
+	// The applied objects keep their plaintext.
+	url, _, _ := unstructured.NestedString(objects[0].Object, "stringData", "url")
+	g.Expect(url).To(Equal("postgres://db.apps:5432"))
+}
+
+func TestRecordModuleVersion(t *testing.T) {
+	g := NewWithT(t)
+
+	r := &KCLRunReconciler{EventRecorder: record.NewFakeRecorder(100)}
+	obj := &v1alpha1.KCLRun{
+		Spec: v1alpha1.KCLRunSpec{
+			Module: &v1alpha1.ModuleSource{
+				URL:                  "oci://ghcr.io/kcl-lang/app",
+				SemVer:               ">=1.0.0",
+				RefuseFailedVersions: true,
+			},
+		},
+		Status: v1alpha1.KCLRunStatus{LastAppliedRevision: "1.0.0@sha256:applied"},
+	}
+
+	// More versions fail than the module history holds.
+	for i := 1; i <= v1alpha1.MaxModuleHistory+2; i++ {
+		version := fmt.Sprintf("1.%d.0", i)
+		r.recordModuleVersion(obj, &sourcev1.Artifact{Revision: version + "@sha256:" + version}, v1alpha1.ModuleVersionFailed)
+	}
+	g.Expect(obj.Status.ModuleHistory).To(HaveLen(v1alpha1.MaxModuleHistory))
+	g.Expect(obj.Status.ModuleHistory[v1alpha1.MaxModuleHistory-1].Version).To(Equal("1.3.0"))
+	g.Expect(obj.GetRefusedModuleVersions()).To(ContainElements("1.1.0", "1.2.0", "1.12.0"))
+	g.Expect(obj.GetRefusedModuleVersions()).To(HaveLen(v1alpha1.MaxModuleHistory + 2))
+
+	// A failed version which is applied later is no longer refused.
+	r.recordModuleVersion(obj, &sourcev1.Artifact{Revision: "1.1.0@sha256:1.1.0"}, v1alpha1.ModuleVersionApplied)
+	g.Expect(obj.Status.FailedModuleVersions).NotTo(ContainElement("1.1.0"))
+	g.Expect(obj.GetRefusedModuleVersions()).NotTo(ContainElement("1.1.0"))
+}
+
+func TestDependsOnPlanned(t *testing.T) {
+	g := NewWithT(t)
+
+	objects, err := ssautil.ReadObjects(bytes.NewReader([]byte(`apiVersion: v1
+kind: Namespace
+metadata:
+  name: apps
+---
+apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: tests.example.com
+spec:
+  group: example.com
+  names:
+    kind: Test
+    plural: tests
+  scope: Namespaced
+---
+apiVersion: example.com/v1
+kind: Test
+metadata:
+  name: test
+  namespace: apps
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app
+  namespace: other
+`)))
+	g.Expect(err).NotTo(HaveOccurred())
+	namespaces, kinds := plannedDefinitions(objects)
+	cr, cm := objects[2], objects[3]
+
+	noMatch := &apimeta.NoKindMatchError{GroupKind: cr.GroupVersionKind().GroupKind()}
+	g.Expect(dependsOnPlanned(cr, fmt.Errorf("dry-run failed: %w", noMatch), namespaces, kinds)).To(BeTrue())
+	notFound := errors.NewNotFound(corev1.Resource("namespaces"), "apps")
+	g.Expect(dependsOnPlanned(cr, notFound, namespaces, kinds)).To(BeTrue())
+
+	// The Namespace of the ConfigMap is not planned.
+	g.Expect(dependsOnPlanned(cm, errors.NewNotFound(corev1.Resource("namespaces"), "other"), namespaces, kinds)).To(BeFalse())
+	g.Expect(dependsOnPlanned(cm, &apimeta.NoKindMatchError{GroupKind: cm.GroupVersionKind().GroupKind()},
+		namespaces, kinds)).To(BeFalse())
+	g.Expect(dependsOnPlanned(cr, errors.NewForbidden(corev1.Resource("tests"), "test", fmt.Errorf("denied")), namespaces, kinds)).To(BeFalse())
+}
@@ -77,7 +77,7 @@ func runInContext(registerControllers func(*testenv.Environment), run func() int
 	var err error
 	// GitRepository
 	utilruntime.Must(sourcev1.AddToScheme(scheme.Scheme))
-	// OCIRepository
+	// OCIRepository and Bucket
 	utilruntime.Must(sourcev1beta2.AddToScheme(scheme.Scheme))
 	// KCLRun
 	utilruntime.Must(v1alpha1.AddToScheme(scheme.Scheme))
@@ -340,6 +340,70 @@ func applyGitRepository(objKey client.ObjectKey, artifactName string, revision s
 	return nil
 }
 
+func applyBucket(objKey client.ObjectKey, artifactName string, revision string) error {
+	bucket := &sourcev1beta2.Bucket{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       sourcev1beta2.BucketKind,
+			APIVersion: sourcev1beta2.GroupVersion.String(),
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      objKey.Name,
+			Namespace: objKey.Namespace,
+		},
+		Spec: sourcev1beta2.BucketSpec{
+			BucketName: "test-bucket",
+			Endpoint:   "s3.example.com",
+			Interval:   metav1.Duration{Duration: time.Minute},
+		},
+	}
+
+	b, _ := os.ReadFile(filepath.Join(testServer.Root(), artifactName))
+	dig := digest.SHA256.FromBytes(b)
+
+	url := fmt.Sprintf("%s/%s", testServer.URL(), artifactName)
+
+	status := sourcev1beta2.BucketStatus{
+		Conditions: []metav1.Condition{
+			{
+				Type:               meta.ReadyCondition,
+				Status:             metav1.ConditionTrue,
+				LastTransitionTime: metav1.Now(),
+				Reason:             meta.SucceededReason,
+			},
+		},
+		Artifact: &sourcev1.Artifact{
+			Path:           url,
+			URL:            url,
+			Revision:       revision,
+			Digest:         dig.String(),
+			LastUpdateTime: metav1.Now(),
+		},
+	}
+
+	opt := []client.PatchOption{
+		client.ForceOwnership,
+		client.FieldOwner("flux-kcl-controller"),
+	}
+
+	if err := k8sClient.Patch(context.Background(), bucket, client.Apply, opt...); err != nil {
+		return err
+	}
+
+	bucket.ManagedFields = nil
+	bucket.Status = status
+
+	statusOpts := &client.SubResourcePatchOptions{
+		PatchOptions: client.PatchOptions{
+			FieldManager: "source-controller",
+		},
+	}
+
+	if err := k8sClient.Status().Patch(context.Background(), bucket, client.Apply, statusOpts); err != nil {
+		return err
+	}
+	return nil
+}
+
 func createVaultTestInstance() (*dockertest.Pool, *dockertest.Resource, error) {
 	// uses a sensible default on windows (tcp/http) and linux/osx (socket)
 	pool, err := dockertest.NewPool("")
@@ -17,9 +17,29 @@ limitations under the License.
 package controller
 
 import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/fluxcd/pkg/ssa"
+	ssautil "github.com/fluxcd/pkg/ssa/utils"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+	"github.com/opencontainers/go-digest"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/kcl-lang/flux-kcl-controller/api/v1alpha1"
+	"github.com/kcl-lang/flux-kcl-controller/internal/kcl"
 )
 
 func extractDigest(revision string) string {
@@ -48,3 +68,314 @@ func HasChanged(action ssa.Action) bool {
 		return true
 	}
 }
+
+// argumentsChecksum returns a digest of the given KCL arguments.
+func argumentsChecksum(arguments []kcl.Argument) (string, error) {
+	var b strings.Builder
+	for _, argument := range arguments {
+		arg, err := argument.Encode()
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(arg + "\n")
+	}
+	return digest.SHA256.FromString(b.String()).String(), nil
+}
+
+// compileCacheKey returns the key of the compilation result of the given
+// artifact, computed from its digest, the namespace of the KCLRun, the KCL
+// package path, the compile config, the module cache digest, the arguments
+// checksum and the digest of the registry credentials.
+func compileCacheKey(obj *v1alpha1.KCLRun, artifact, moduleCache *sourcev1.Artifact,
+	argsChecksum, credentialsDigest string) (string, error) {
+	config, err := json.Marshal(obj.Spec.Config)
+	if err != nil {
+		return "", err
+	}
+	var moduleCacheDigest string
+	if moduleCache != nil {
+		moduleCacheDigest = moduleCache.Digest
+	}
+	return digest.SHA256.FromString(strings.Join([]string{
+		artifact.Digest,
+		artifact.Revision,
+		obj.GetNamespace(),
+		obj.Spec.Path,
+		string(config),
+		moduleCacheDigest,
+		argsChecksum,
+		credentialsDigest,
+	}, "\n")).String(), nil
+}
+
+// configDigest returns the digest of the path, the compile config, the
+// arguments checksum, the patches, the image overrides and the post-build
+// variables of the KCLRun.
+func configDigest(obj *v1alpha1.KCLRun, argsChecksum string, vars map[string]string) (string, error) {
+	config, err := json.Marshal(obj.Spec.Config)
+	if err != nil {
+		return "", err
+	}
+	patches, err := json.Marshal(obj.Spec.Patches)
+	if err != nil {
+		return "", err
+	}
+	images, err := json.Marshal(obj.Spec.Images)
+	if err != nil {
+		return "", err
+	}
+	substitutions, err := json.Marshal(vars)
+	if err != nil {
+		return "", err
+	}
+	return digest.SHA256.FromString(strings.Join([]string{
+		obj.Spec.Path,
+		string(config),
+		argsChecksum,
+		string(patches),
+		string(images),
+		string(substitutions),
+	}, "\n")).String(), nil
+}
+
+// inventoryDigest returns the digest of the entries of the inventory,
+// or an empty string if the inventory is nil.
+func inventoryDigest(inv *v1alpha1.ResourceInventory) string {
+	if inv == nil {
+		return ""
+	}
+	data, err := json.Marshal(inv.Entries)
+	if err != nil {
+		return ""
+	}
+	return digest.SHA256.FromBytes(data).String()
+}
+
+// newSnapshot returns the snapshot of an apply started at the given time
+// and finishing now.
+func newSnapshot(revision, configDigest string, inv *v1alpha1.ResourceInventory, started time.Time, outcome string) v1alpha1.Snapshot {
+	return v1alpha1.Snapshot{
+		Revision:        revision,
+		ConfigDigest:    configDigest,
+		InventoryDigest: inventoryDigest(inv),
+		StartedAt:       metav1.NewTime(started),
+		FinishedAt:      metav1.Now(),
+		Outcome:         outcome,
+	}
+}
+
+// recordSnapshot prepends the snapshot to the bounded history of the KCLRun.
+// A snapshot repeating the most recent one only refreshes its timestamps.
+func recordSnapshot(obj *v1alpha1.KCLRun, snapshot v1alpha1.Snapshot) {
+	history := obj.Status.History
+	if len(history) > 0 &&
+		history[0].Revision == snapshot.Revision &&
+		history[0].ConfigDigest == snapshot.ConfigDigest &&
+		history[0].InventoryDigest == snapshot.InventoryDigest &&
+		history[0].Outcome == snapshot.Outcome {
+		history = history[1:]
+	}
+	history = append([]v1alpha1.Snapshot{snapshot}, history...)
+	if len(history) > v1alpha1.MaxHistory {
+		history = history[:v1alpha1.MaxHistory]
+	}
+	obj.Status.History = history
+}
+
+// isRolledBack returns true if the last apply of the KCLRun is the rollback
+// of the revision and config digest, after their health checks failed.
+func isRolledBack(obj *v1alpha1.KCLRun, revision, configDigest string) bool {
+	history := obj.Status.History
+	return len(history) > 1 &&
+		history[0].Outcome == v1alpha1.RolledBackOutcome &&
+		history[1].Outcome == v1alpha1.FailedOutcome &&
+		history[1].Revision == revision &&
+		history[1].ConfigDigest == configDigest
+}
+
+// redactedValue replaces the values of the Secrets redacted from manifests.
+const redactedValue = "***"
+
+// redactSecrets returns the manifests with the values of the 'data' and
+// 'stringData' of the Secrets replaced with a mask.
+func redactSecrets(manifests []byte) ([]byte, error) {
+	objects, err := ssautil.ReadObjects(bytes.NewReader(manifests))
+	if err != nil {
+		return nil, err
+	}
+	redacted := false
+	for _, o := range objects {
+		ok, err := redactSecret(o)
+		if err != nil {
+			return nil, err
+		}
+		redacted = redacted || ok
+	}
+	if !redacted {
+		return manifests, nil
+	}
+	out, err := ssautil.ObjectsToYAML(objects)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(out), nil
+}
+
+// redactSecret replaces the values of the 'data' and 'stringData' of the
+// object with a mask if it is a Secret, and returns true if any was replaced.
+func redactSecret(o *unstructured.Unstructured) (bool, error) {
+	if o.GetAPIVersion() != "v1" || o.GetKind() != "Secret" {
+		return false, nil
+	}
+	redacted := false
+	for _, field := range []string{"data", "stringData"} {
+		data, found, err := unstructured.NestedMap(o.Object, field)
+		if err != nil {
+			return false, fmt.Errorf("invalid %s of 'Secret/%s': %w", field, o.GetName(), err)
+		}
+		if !found {
+			continue
+		}
+		for k := range data {
+			data[k] = redactedValue
+		}
+		if err := unstructured.SetNestedMap(o.Object, data, field); err != nil {
+			return false, err
+		}
+		redacted = true
+	}
+	return redacted, nil
+}
+
+// renderObjects returns the YAML manifests of the objects, with the values of
+// the redacted objects replaced with a mask. The objects are not modified.
+func renderObjects(objects, redacted []*unstructured.Unstructured) ([]byte, error) {
+	rendered := make([]*unstructured.Unstructured, len(objects))
+	for i, o := range objects {
+		rendered[i] = o
+		if slices.Contains(redacted, o) {
+			rendered[i] = o.DeepCopy()
+			if _, err := redactSecret(rendered[i]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	out, err := ssautil.ObjectsToYAML(rendered)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(out), nil
+}
+
+// plannedDefinitions returns the names of the Namespaces and the group kinds
+// of the CustomResourceDefinitions of the objects.
+func plannedDefinitions(objects []*unstructured.Unstructured) (map[string]bool, map[schema.GroupKind]bool) {
+	namespaces := make(map[string]bool)
+	kinds := make(map[schema.GroupKind]bool)
+	for _, u := range objects {
+		switch {
+		case u.GetAPIVersion() == "v1" && u.GetKind() == "Namespace":
+			namespaces[u.GetName()] = true
+		case ssautil.IsCRD(u):
+			group, _, _ := unstructured.NestedString(u.Object, "spec", "group")
+			kind, _, _ := unstructured.NestedString(u.Object, "spec", "names", "kind")
+			kinds[schema.GroupKind{Group: group, Kind: kind}] = true
+		}
+	}
+	return namespaces, kinds
+}
+
+// dependsOnPlanned returns true if the dry-run apply of the object failed
+// because its Namespace or its custom resource kind is one of the planned
+// definitions, which do not exist before they are applied.
+func dependsOnPlanned(u *unstructured.Unstructured, err error,
+	namespaces map[string]bool, kinds map[schema.GroupKind]bool) bool {
+	switch {
+	case apimeta.IsNoMatchError(err):
+		return kinds[u.GroupVersionKind().GroupKind()]
+	case apierrors.IsNotFound(err):
+		return u.GetNamespace() != "" && namespaces[u.GetNamespace()]
+	default:
+		return false
+	}
+}
+
+// checkControlledBy returns an error if the object exists and is not
+// controlled by the KCLRun, so that it is not taken over.
+func checkControlledBy(obj *v1alpha1.KCLRun, object metav1.Object) error {
+	if object.GetResourceVersion() == "" || metav1.IsControlledBy(object, obj) {
+		return nil
+	}
+	return fmt.Errorf("'%s' already exists and is not controlled by the KCLRun", object.GetName())
+}
+
+// compressManifests returns the gzip compressed manifests.
+func compressManifests(manifests []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	if _, err := gw.Write(manifests); err != nil {
+		return nil, err
+	}
+	if err := gw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompressManifests returns the manifests compressed with compressManifests.
+func decompressManifests(data []byte) ([]byte, error) {
+	gr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer gr.Close()
+	return io.ReadAll(gr)
+}
+
+// inlineKCLMod is the kcl.mod file of the inline sources without dependencies.
+const inlineKCLMod = `[package]
+name = "inline"
+version = "0.0.1"
+`
+
+// inlineArtifact returns the artifact of the inline KCL source, revisioned
+// by the digest of its content.
+func inlineArtifact(inline *v1alpha1.InlineSource) *sourcev1.Artifact {
+	revision := digest.SHA256.FromString(inline.KCLMod + "\n" + inline.Code).String()
+	return &sourcev1.Artifact{
+		Revision: revision,
+		Digest:   revision,
+	}
+}
+
+// moduleArtifact returns the artifact of the KCL module resolved from the
+// module source, revisioned like the artifacts of OCIRepositories.
+func moduleArtifact(src *v1alpha1.ModuleSource, module *kcl.ResolvedModule) *sourcev1.Artifact {
+	return &sourcev1.Artifact{
+		URL:      src.URL,
+		Revision: module.Revision(),
+		Digest:   module.Digest,
+	}
+}
+
+// moduleVersion returns the tag of a module revision in the '<tag>@<digest>'
+// format, or an empty string if the revision has no tag.
+func moduleVersion(revision string) string {
+	tag, _, ok := strings.Cut(revision, "@")
+	if !ok {
+		return ""
+	}
+	return tag
+}
+
+// writeInlineSource writes the inline KCL source as a package in dir.
+func writeInlineSource(dir string, inline *v1alpha1.InlineSource) error {
+	kclMod := inline.KCLMod
+	if kclMod == "" {
+		kclMod = inlineKCLMod
+	}
+	if err := os.WriteFile(filepath.Join(dir, "kcl.mod"), []byte(kclMod), 0o600); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "main.k"), []byte(inline.Code), 0o600)
+}
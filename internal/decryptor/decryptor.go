@@ -0,0 +1,296 @@
+/*
+Copyright 2024 The KCL authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package decryptor
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	ssautil "github.com/fluxcd/pkg/ssa/utils"
+	"github.com/getsops/sops/v3"
+	"github.com/getsops/sops/v3/aes"
+	"github.com/getsops/sops/v3/config"
+	"github.com/getsops/sops/v3/keyservice"
+	"github.com/getsops/sops/v3/pgp"
+	sopsjson "github.com/getsops/sops/v3/stores/json"
+	sopsyaml "github.com/getsops/sops/v3/stores/yaml"
+	"gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	// AgeKeyExt is the extension of the Secret data keys holding age identities.
+	AgeKeyExt = ".agekey"
+	// PGPKeyExt is the extension of the Secret data keys holding armored
+	// OpenPGP private keys.
+	PGPKeyExt = ".asc"
+	// sopsKey is the top level key of the SOPS metadata.
+	sopsKey = "sops"
+	// secRingName is the name of the OpenPGP keyring in the GnuPG home.
+	secRingName = "secring.gpg"
+)
+
+// Decryptor decrypts SOPS encrypted documents and Kubernetes Secrets
+// with age identities and OpenPGP private keys.
+type Decryptor struct {
+	keyServices []keyservice.KeyServiceClient
+}
+
+// New returns a Decryptor using the age identities and the OpenPGP
+// private keys of the given Secret data, and a function removing the
+// keyring it writes in a temporary directory.
+func New(keys map[string][]byte) (*Decryptor, func(), error) {
+	server := &keyServer{}
+	var secRing bytes.Buffer
+	for name, value := range keys {
+		switch {
+		case strings.HasSuffix(name, AgeKeyExt):
+			if err := server.ageIdentities.Import(string(value)); err != nil {
+				return nil, nil, fmt.Errorf("failed to parse the age identities of '%s': %w", name, err)
+			}
+		case strings.HasSuffix(name, PGPKeyExt):
+			if err := readArmoredKeys(&secRing, value); err != nil {
+				return nil, nil, fmt.Errorf("failed to parse the OpenPGP keys of '%s': %w", name, err)
+			}
+		}
+	}
+
+	cleanup := func() {}
+	if secRing.Len() > 0 {
+		// The OpenPGP keys are read by SOPS from the keyring of a GnuPG home.
+		home, err := pgp.NewGnuPGHome()
+		if err != nil {
+			return nil, nil, err
+		}
+		cleanup = func() { _ = home.Cleanup() }
+		if err := os.WriteFile(filepath.Join(home.String(), secRingName), secRing.Bytes(), 0o600); err != nil {
+			cleanup()
+			return nil, nil, fmt.Errorf("failed to write the OpenPGP keyring: %w", err)
+		}
+		server.gnuPGHome = home
+	}
+	return &Decryptor{
+		keyServices: []keyservice.KeyServiceClient{keyservice.NewCustomLocalClient(server)},
+	}, cleanup, nil
+}
+
+// readArmoredKeys writes the binary packets of the armored OpenPGP keys to w.
+func readArmoredKeys(w io.Writer, armored []byte) error {
+	block, err := armor.Decode(bytes.NewReader(armored))
+	if err != nil {
+		return err
+	}
+	packets, err := io.ReadAll(block.Body)
+	if err != nil {
+		return err
+	}
+	if _, err := openpgp.ReadKeyRing(bytes.NewReader(packets)); err != nil {
+		return err
+	}
+	_, err = w.Write(packets)
+	return err
+}
+
+// IsEncryptedSecret returns true if the object is a Secret holding SOPS metadata.
+func IsEncryptedSecret(u *unstructured.Unstructured) bool {
+	if !ssautil.IsSecret(u) {
+		return false
+	}
+	_, found, _ := unstructured.NestedMap(u.Object, sopsKey)
+	return found
+}
+
+// IsEncryptedDocument returns true if the data is a YAML or JSON document
+// holding SOPS metadata.
+func IsEncryptedDocument(data []byte) bool {
+	var doc struct {
+		SOPS *struct {
+			MAC string `yaml:"mac"`
+		} `yaml:"sops"`
+	}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return false
+	}
+	return doc.SOPS != nil && doc.SOPS.MAC != ""
+}
+
+// DecryptObjects decrypts in place the encrypted Secrets of the objects,
+// which are read from the compiled manifests and may have been transformed
+// since. The message authentication code of the Secrets is verified against
+// the documents of the compiled manifests, whose key order is preserved.
+func (d *Decryptor) DecryptObjects(compiled []byte, objects []*unstructured.Unstructured) error {
+	var dataKeys map[string][]byte
+	for _, u := range objects {
+		if !IsEncryptedSecret(u) {
+			continue
+		}
+		if dataKeys == nil {
+			var err error
+			if dataKeys, err = d.verifyManifests(compiled); err != nil {
+				return err
+			}
+		}
+		mac, _, _ := unstructured.NestedString(u.Object, sopsKey, "mac")
+		key, ok := dataKeys[mac]
+		if !ok {
+			return fmt.Errorf("failed to decrypt %s: the SOPS metadata does not match a compiled Secret",
+				ssautil.FmtUnstructured(u))
+		}
+		if err := decryptObject(u, key); err != nil {
+			return fmt.Errorf("failed to decrypt %s: %w", ssautil.FmtUnstructured(u), err)
+		}
+	}
+	return nil
+}
+
+// decryptObject decrypts the values of the object with the data key, without
+// verifying its MAC which the transformations of the object invalidate.
+func decryptObject(u *unstructured.Unstructured, key []byte) error {
+	data, err := json.Marshal(u.Object)
+	if err != nil {
+		return err
+	}
+	store := sopsjson.NewStore(&config.JSONStoreConfig{})
+	tree, err := store.LoadEncryptedFile(data)
+	if err != nil {
+		return err
+	}
+	if _, err := tree.Decrypt(key, aes.NewCipher()); err != nil {
+		return err
+	}
+	plaintext, err := store.EmitPlainFile(tree.Branches)
+	if err != nil {
+		return err
+	}
+	object := map[string]interface{}{}
+	if err := json.Unmarshal(plaintext, &object); err != nil {
+		return err
+	}
+	u.Object = object
+	return nil
+}
+
+// DecryptDocument returns the plaintext of a SOPS encrypted YAML or JSON
+// document, without its SOPS metadata and in the format of the document.
+func (d *Decryptor) DecryptDocument(data []byte) ([]byte, error) {
+	if !IsEncryptedDocument(data) {
+		return nil, errors.New("the document holds no SOPS metadata")
+	}
+	var store sops.Store = sopsyaml.NewStore(&config.YAMLStoreConfig{Indent: 2})
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		store = sopsjson.NewStore(&config.JSONStoreConfig{})
+	}
+	tree, err := store.LoadEncryptedFile(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode the document: %w", err)
+	}
+	if _, err := d.decryptTree(&tree); err != nil {
+		return nil, err
+	}
+	return store.EmitPlainFile(tree.Branches)
+}
+
+// verifyManifests returns the data keys of the encrypted Secrets of the
+// manifests by MAC, after verifying the MAC of each Secret.
+func (d *Decryptor) verifyManifests(manifests []byte) (map[string][]byte, error) {
+	dataKeys := make(map[string][]byte)
+	decoder := yaml.NewDecoder(bytes.NewReader(manifests))
+	for {
+		var doc yaml.Node
+		if err := decoder.Decode(&doc); err != nil {
+			if errors.Is(err, io.EOF) {
+				return dataKeys, nil
+			}
+			return nil, fmt.Errorf("failed to decode the compiled manifests: %w", err)
+		}
+		if len(doc.Content) != 1 || doc.Content[0].Kind != yaml.MappingNode || !isSecretNode(doc.Content[0]) {
+			continue
+		}
+		data, err := yaml.Marshal(&doc)
+		if err != nil {
+			return nil, err
+		}
+		if !IsEncryptedDocument(data) {
+			continue
+		}
+		tree, err := sopsyaml.NewStore(&config.YAMLStoreConfig{}).LoadEncryptedFile(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to verify Secret/%s: %w", nodeName(doc.Content[0]), err)
+		}
+		key, err := d.decryptTree(&tree)
+		if err != nil {
+			return nil, fmt.Errorf("failed to verify Secret/%s: %w", nodeName(doc.Content[0]), err)
+		}
+		dataKeys[tree.Metadata.MessageAuthenticationCode] = key
+	}
+}
+
+// decryptTree decrypts the values of the tree in place after verifying its
+// MAC, and returns its data key.
+func (d *Decryptor) decryptTree(tree *sops.Tree) ([]byte, error) {
+	key, err := tree.Metadata.GetDataKeyWithKeyServices(d.keyServices, sops.DefaultDecryptionOrder)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt the data key: %w", err)
+	}
+	cipher := aes.NewCipher()
+	mac, err := tree.Decrypt(key, cipher)
+	if err != nil {
+		return nil, err
+	}
+	originalMAC, err := cipher.Decrypt(tree.Metadata.MessageAuthenticationCode, key,
+		tree.Metadata.LastModified.Format(time.RFC3339))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt the MAC: %w", err)
+	}
+	if originalMAC != mac {
+		return nil, errors.New("the MAC does not match the content")
+	}
+	return key, nil
+}
+
+// isSecretNode returns true if the mapping is a v1 Secret.
+func isSecretNode(root *yaml.Node) bool {
+	var object struct {
+		APIVersion string `yaml:"apiVersion"`
+		Kind       string `yaml:"kind"`
+	}
+	return root.Decode(&object) == nil && object.APIVersion == "v1" && object.Kind == "Secret"
+}
+
+// nodeName returns the namespace and the name of the object mapping.
+func nodeName(root *yaml.Node) string {
+	var object struct {
+		Metadata struct {
+			Name      string `yaml:"name"`
+			Namespace string `yaml:"namespace"`
+		} `yaml:"metadata"`
+	}
+	_ = root.Decode(&object)
+	if object.Metadata.Namespace == "" {
+		return object.Metadata.Name
+	}
+	return object.Metadata.Namespace + "/" + object.Metadata.Name
+}
@@ -0,0 +1,228 @@
+/*
+Copyright 2024 The KCL authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package decryptor
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	ssautil "github.com/fluxcd/pkg/ssa/utils"
+	"github.com/getsops/sops/v3"
+	"github.com/getsops/sops/v3/aes"
+	sopsage "github.com/getsops/sops/v3/age"
+	"github.com/getsops/sops/v3/config"
+	"github.com/getsops/sops/v3/keys"
+	"github.com/getsops/sops/v3/pgp"
+	sopsjson "github.com/getsops/sops/v3/stores/json"
+	sopsyaml "github.com/getsops/sops/v3/stores/yaml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// encryptDocument encrypts the document with the master keys like SOPS does
+// with the 'encrypted_regex: ^(data|stringData|password)$' creation rule.
+func encryptDocument(t *testing.T, store sops.Store, plaintext string, masterKeys ...keys.MasterKey) string {
+	branches, err := store.LoadPlainFile([]byte(plaintext))
+	require.NoError(t, err)
+	tree := sops.Tree{
+		Branches: branches,
+		Metadata: sops.Metadata{
+			KeyGroups:      []sops.KeyGroup{masterKeys},
+			EncryptedRegex: "^(data|stringData|password)$",
+			Version:        "3.9.4",
+		},
+	}
+
+	dataKey := make([]byte, 32)
+	_, err = rand.Read(dataKey)
+	require.NoError(t, err)
+	for _, key := range masterKeys {
+		require.NoError(t, key.Encrypt(dataKey))
+	}
+	cipher := aes.NewCipher()
+	mac, err := tree.Encrypt(dataKey, cipher)
+	require.NoError(t, err)
+	tree.Metadata.LastModified = time.Now().UTC()
+	tree.Metadata.MessageAuthenticationCode, err = cipher.Encrypt(mac, dataKey,
+		tree.Metadata.LastModified.Format(time.RFC3339))
+	require.NoError(t, err)
+
+	out, err := store.EmitEncryptedFile(tree)
+	require.NoError(t, err)
+	return string(out)
+}
+
+// ageKeys are test age identities with their recipient.
+var ageKeys = [][2]string{
+	{"AGE-SECRET-KEY-1EAVE0ATHY5KSMC6HPYY5ZNLQCFP4P49MHS4ZNUTUEERFF3YTWDGSHXX3PX",
+		"age1q5y5xqramjsrkjma62djct6rqkcv2dxnsyx52kuu5d9jlhvyr99qlxpfyh"},
+	{"AGE-SECRET-KEY-1LH9RC7XQJ5U8JAM5ZQAFSVXECMSQH48ZV0F77X2RE7T32GU84L6Q2AZZNM",
+		"age1uy72cn5dyltwwhey9gvz2rnr7xs5jquphlkz7g8l90md07vt3sks4davld"},
+}
+
+func newAgeDecryptor(t *testing.T, i int) (*Decryptor, keys.MasterKey) {
+	d, cleanup, err := New(map[string][]byte{"identity" + AgeKeyExt: []byte(ageKeys[i][0])})
+	require.NoError(t, err)
+	t.Cleanup(cleanup)
+	key, err := sopsage.MasterKeyFromRecipient(ageKeys[i][1])
+	require.NoError(t, err)
+	return d, key
+}
+
+func newPGPDecryptor(t *testing.T) (*Decryptor, keys.MasterKey) {
+	entity, err := openpgp.NewEntity("test", "", "test@example.com", nil)
+	require.NoError(t, err)
+	var private bytes.Buffer
+	w, err := armor.Encode(&private, openpgp.PrivateKeyType, nil)
+	require.NoError(t, err)
+	require.NoError(t, entity.SerializePrivate(w, nil))
+	require.NoError(t, w.Close())
+	d, cleanup, err := New(map[string][]byte{"key" + PGPKeyExt: private.Bytes()})
+	require.NoError(t, err)
+	t.Cleanup(cleanup)
+
+	var public bytes.Buffer
+	require.NoError(t, entity.Serialize(&public))
+	pubRing := filepath.Join(t.TempDir(), "pubring.gpg")
+	require.NoError(t, os.WriteFile(pubRing, public.Bytes(), 0o600))
+	key := pgp.NewMasterKeyFromFingerprint(fmt.Sprintf("%X", entity.PrimaryKey.Fingerprint))
+	pgp.PubRing(pubRing).ApplyToMasterKey(key)
+	return d, key
+}
+
+const secret = `apiVersion: v1
+kind: Secret
+metadata:
+  name: db
+  namespace: apps
+type: Opaque
+stringData:
+  username: admin
+  port: "5432"
+data:
+  password: cGFzc3dvcmQ=
+`
+
+func TestDecryptObjects(t *testing.T) {
+	d, key := newAgeDecryptor(t, 0)
+	compiled := `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+data:
+  key: value
+---
+` + encryptDocument(t, sopsyaml.NewStore(&config.YAMLStoreConfig{}), secret, key)
+	assert.NotContains(t, compiled, "admin")
+
+	objects, err := ssautil.ReadObjects(strings.NewReader(compiled))
+	require.NoError(t, err)
+	require.Len(t, objects, 2)
+	assert.False(t, IsEncryptedSecret(objects[0]))
+	assert.True(t, IsEncryptedSecret(objects[1]))
+
+	// The transformations of the objects do not invalidate the MAC.
+	objects[1].SetLabels(map[string]string{"patched": "true"})
+	require.NoError(t, d.DecryptObjects([]byte(compiled), objects))
+
+	assert.False(t, IsEncryptedSecret(objects[1]))
+	stringData, _, _ := unstructured.NestedStringMap(objects[1].Object, "stringData")
+	assert.Equal(t, map[string]string{"username": "admin", "port": "5432"}, stringData)
+	data, _, _ := unstructured.NestedStringMap(objects[1].Object, "data")
+	assert.Equal(t, map[string]string{"password": "cGFzc3dvcmQ="}, data)
+	assert.Equal(t, "true", objects[1].GetLabels()["patched"])
+	value, _, _ := unstructured.NestedString(objects[0].Object, "data", "key")
+	assert.Equal(t, "value", value)
+}
+
+func TestDecryptObjectsErrors(t *testing.T) {
+	d, key := newAgeDecryptor(t, 0)
+	encrypted := encryptDocument(t, sopsyaml.NewStore(&config.YAMLStoreConfig{}), secret, key)
+
+	// A value moved to another key fails the authentication.
+	tampered := strings.Replace(encrypted, "username: ENC", "user: ENC", 1)
+	objects, err := ssautil.ReadObjects(strings.NewReader(tampered))
+	require.NoError(t, err)
+	err = d.DecryptObjects([]byte(tampered), objects)
+	assert.ErrorContains(t, err, "failed to verify Secret/apps/db")
+
+	// A plaintext value added to the compiled Secret fails the MAC.
+	var doc map[string]interface{}
+	require.NoError(t, yaml.Unmarshal([]byte(encrypted), &doc))
+	doc["immutable"] = true
+	out, err := yaml.Marshal(doc)
+	require.NoError(t, err)
+	objects, err = ssautil.ReadObjects(bytes.NewReader(out))
+	require.NoError(t, err)
+	err = d.DecryptObjects(out, objects)
+	assert.ErrorContains(t, err, "the MAC does not match the content")
+
+	// An object which is not part of the compiled manifests is refused.
+	objects, err = ssautil.ReadObjects(strings.NewReader(encrypted))
+	require.NoError(t, err)
+	err = d.DecryptObjects([]byte(encryptDocument(t, sopsyaml.NewStore(&config.YAMLStoreConfig{}), secret, key)), objects)
+	assert.ErrorContains(t, err, "does not match a compiled Secret")
+
+	// The data key can't be decrypted without a matching identity.
+	other, _ := newAgeDecryptor(t, 1)
+	err = other.DecryptObjects([]byte(encrypted), objects)
+	assert.ErrorContains(t, err, "failed to decrypt the data key")
+	assert.NotContains(t, err.Error(), "admin")
+}
+
+func TestDecryptDocument(t *testing.T) {
+	d, key := newPGPDecryptor(t)
+
+	values := `app:
+  replicas: 3
+  ratio: 0.5
+  debug: true
+  password: s3cr3t
+tags:
+- a
+- b
+`
+	encrypted := encryptDocument(t, sopsyaml.NewStore(&config.YAMLStoreConfig{}), values, key)
+	assert.True(t, IsEncryptedDocument([]byte(encrypted)))
+	assert.False(t, IsEncryptedDocument([]byte(values)))
+	assert.NotContains(t, encrypted, "s3cr3t")
+
+	plaintext, err := d.DecryptDocument([]byte(encrypted))
+	require.NoError(t, err)
+	assert.YAMLEq(t, values, string(plaintext))
+
+	// A JSON document is decrypted into JSON.
+	encrypted = encryptDocument(t, sopsjson.NewStore(&config.JSONStoreConfig{}),
+		`{"app": {"replicas": 3, "ratio": 0.5, "debug": true, "password": "s3cr3t"}, "tags": ["a", "b"]}`, key)
+	plaintext, err = d.DecryptDocument([]byte(encrypted))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"app": {"replicas": 3, "ratio": 0.5, "debug": true, "password": "s3cr3t"}, "tags": ["a", "b"]}`,
+		string(plaintext))
+
+	_, err = d.DecryptDocument([]byte(values))
+	assert.ErrorContains(t, err, "holds no SOPS metadata")
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2024 The KCL authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package decryptor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	sopsage "github.com/getsops/sops/v3/age"
+	"github.com/getsops/sops/v3/keyservice"
+	"github.com/getsops/sops/v3/pgp"
+)
+
+// keyServer is a local SOPS key service decrypting the data keys with the
+// keys of the decryption Secret only, never with the keys of the controller
+// environment.
+type keyServer struct {
+	keyservice.UnimplementedKeyServiceServer
+
+	ageIdentities sopsage.ParsedIdentities
+	// gnuPGHome holds the keyring of the OpenPGP private keys.
+	gnuPGHome pgp.GnuPGHome
+}
+
+// Decrypt decrypts the data key of the request with the age identities or
+// the OpenPGP private keys.
+func (s *keyServer) Decrypt(_ context.Context, req *keyservice.DecryptRequest) (*keyservice.DecryptResponse, error) {
+	var plaintext []byte
+	var err error
+	switch k := req.GetKey().GetKeyType().(type) {
+	case *keyservice.Key_AgeKey:
+		if len(s.ageIdentities) == 0 {
+			return nil, errors.New("no age identity")
+		}
+		key := &sopsage.MasterKey{Recipient: k.AgeKey.Recipient, EncryptedKey: string(req.Ciphertext)}
+		s.ageIdentities.ApplyToMasterKey(key)
+		plaintext, err = key.Decrypt()
+	case *keyservice.Key_PgpKey:
+		if s.gnuPGHome == "" {
+			return nil, errors.New("no OpenPGP key")
+		}
+		key := pgp.NewMasterKeyFromFingerprint(k.PgpKey.Fingerprint)
+		key.EncryptedKey = string(req.Ciphertext)
+		s.gnuPGHome.ApplyToMasterKey(key)
+		plaintext, err = key.Decrypt()
+	default:
+		return nil, fmt.Errorf("unsupported key type %T, only age and OpenPGP keys are supported", k)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &keyservice.DecryptResponse{Plaintext: plaintext}, nil
+}
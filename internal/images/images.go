@@ -0,0 +1,126 @@
+/*
+Copyright 2024 The KCL authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package images
+
+import (
+	"fmt"
+	"strings"
+
+	ssautil "github.com/fluxcd/pkg/ssa/utils"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/kcl-lang/flux-kcl-controller/api/v1alpha1"
+)
+
+// podSpecPaths maps the workload kinds to the path of their pod spec.
+var podSpecPaths = map[schema.GroupKind][]string{
+	{Kind: "Pod"}:                        {"spec"},
+	{Group: "apps", Kind: "Deployment"}:  {"spec", "template", "spec"},
+	{Group: "apps", Kind: "StatefulSet"}: {"spec", "template", "spec"},
+	{Group: "apps", Kind: "DaemonSet"}:   {"spec", "template", "spec"},
+	{Group: "batch", Kind: "Job"}:        {"spec", "template", "spec"},
+	{Group: "batch", Kind: "CronJob"}:    {"spec", "jobTemplate", "spec", "template", "spec"},
+}
+
+// Apply overrides the images of the containers and init containers of the
+// workloads with the first image override matching their name.
+func Apply(objects []*unstructured.Unstructured, images []v1alpha1.Image) error {
+	for _, u := range objects {
+		path, ok := podSpecPaths[u.GroupVersionKind().GroupKind()]
+		if !ok {
+			continue
+		}
+		for _, field := range []string{"containers", "initContainers"} {
+			if err := setImages(u, append(append([]string{}, path...), field), images); err != nil {
+				return fmt.Errorf("failed to set the images of %s: %w", ssautil.FmtUnstructured(u), err)
+			}
+		}
+	}
+	return nil
+}
+
+// setImages overrides the images of the containers found at the given path.
+func setImages(u *unstructured.Unstructured, path []string, images []v1alpha1.Image) error {
+	containers, found, err := unstructured.NestedSlice(u.Object, path...)
+	if err != nil || !found {
+		return err
+	}
+	changed := false
+	for _, c := range containers {
+		container, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		image, ok := container["image"].(string)
+		if !ok {
+			continue
+		}
+		if newImage := Override(image, images); newImage != image {
+			container["image"] = newImage
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return unstructured.SetNestedSlice(u.Object, containers, path...)
+}
+
+// Override returns the image with the name, tag and digest replaced according
+// to the first image override matching its name, or the image unchanged.
+// A digest override drops the tag, and a tag override drops the digest.
+func Override(image string, images []v1alpha1.Image) string {
+	name, tag, digest := split(image)
+	for _, img := range images {
+		if img.Name != name {
+			continue
+		}
+		if img.NewName != "" {
+			name = img.NewName
+		}
+		switch {
+		case img.Digest != "":
+			tag, digest = "", img.Digest
+		case img.NewTag != "":
+			tag, digest = img.NewTag, ""
+		}
+		return join(name, tag, digest)
+	}
+	return image
+}
+
+// split returns the name, the tag and the digest of an image reference.
+func split(image string) (name, tag, digest string) {
+	name, digest, _ = strings.Cut(image, "@")
+	// A colon before the last slash separates the registry host from its port.
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, tag = name[:i], name[i+1:]
+	}
+	return name, tag, digest
+}
+
+// join returns the image reference of the name, the tag and the digest.
+func join(name, tag, digest string) string {
+	if tag != "" {
+		name += ":" + tag
+	}
+	if digest != "" {
+		name += "@" + digest
+	}
+	return name
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2024 The KCL authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package images
+
+import (
+	"strings"
+	"testing"
+
+	ssautil "github.com/fluxcd/pkg/ssa/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/kcl-lang/flux-kcl-controller/api/v1alpha1"
+)
+
+const digest = "sha256:6d8e3a6d0c0c5e5f1a6e7e4e2d0b6b3b0f3c2f1b9e0b1a2c3d4e5f60718293a4"
+
+func TestOverride(t *testing.T) {
+	images := []v1alpha1.Image{
+		{Name: "nginx", NewTag: "1.27"},
+		{Name: "registry.local:5000/app", NewName: "ghcr.io/org/app"},
+		{Name: "envoy", NewName: "mirror.local/envoy", Digest: digest, NewTag: "ignored"},
+		{Name: "busybox", NewTag: "1.36"},
+	}
+	tests := []struct {
+		image string
+		want  string
+	}{
+		{"nginx", "nginx:1.27"},
+		{"nginx:1.25", "nginx:1.27"},
+		{"nginx@" + digest, "nginx:1.27"},
+		{"docker.io/nginx:1.25", "docker.io/nginx:1.25"},
+		{"registry.local:5000/app:v1", "ghcr.io/org/app:v1"},
+		{"registry.local:5000/app", "ghcr.io/org/app"},
+		{"envoy:1.30", "mirror.local/envoy@" + digest},
+		{"busybox:1.35@" + digest, "busybox:1.36"},
+		{"redis:7", "redis:7"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, Override(tt.image, images), tt.image)
+	}
+}
+
+func TestApply(t *testing.T) {
+	objects, err := ssautil.ReadObjects(strings.NewReader(`---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+spec:
+  template:
+    spec:
+      initContainers:
+      - name: init
+        image: busybox:1.35
+      containers:
+      - name: app
+        image: nginx:1.25
+---
+apiVersion: batch/v1
+kind: CronJob
+metadata:
+  name: job
+spec:
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          containers:
+          - name: job
+            image: busybox
+---
+apiVersion: v1
+kind: Pod
+metadata:
+  name: pod
+spec:
+  containers:
+  - name: pod
+    image: nginx
+---
+apiVersion: example.com/v1
+kind: Deployment
+metadata:
+  name: custom
+spec:
+  template:
+    spec:
+      containers:
+      - name: custom
+        image: nginx
+`))
+	require.NoError(t, err)
+
+	err = Apply(objects, []v1alpha1.Image{
+		{Name: "nginx", NewTag: "1.27"},
+		{Name: "busybox", NewName: "ghcr.io/org/busybox", NewTag: "1.36"},
+	})
+	require.NoError(t, err)
+
+	image := func(u *unstructured.Unstructured, path ...string) string {
+		containers, _, err := unstructured.NestedSlice(u.Object, path...)
+		require.NoError(t, err)
+		require.NotEmpty(t, containers)
+		return containers[0].(map[string]interface{})["image"].(string)
+	}
+	assert.Equal(t, "nginx:1.27", image(objects[0], "spec", "template", "spec", "containers"))
+	assert.Equal(t, "ghcr.io/org/busybox:1.36", image(objects[0], "spec", "template", "spec", "initContainers"))
+	assert.Equal(t, "ghcr.io/org/busybox:1.36", image(objects[1], "spec", "jobTemplate", "spec", "template", "spec", "containers"))
+	assert.Equal(t, "nginx:1.27", image(objects[2], "spec", "containers"))
+	assert.Equal(t, "nginx", image(objects[3], "spec", "template", "spec", "containers"))
+}
@@ -0,0 +1,142 @@
+package kcl
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+
+	"github.com/kcl-lang/flux-kcl-controller/api/v1alpha1"
+)
+
+// Argument is a KCL top level argument read by the option function.
+type Argument struct {
+	// Name of the argument.
+	Name string
+	// Value of the argument. A string is passed verbatim, a Literal is left
+	// to KCL to interpret and any other value is converted to the matching
+	// KCL type, e.g. a map[string]any becomes a dict.
+	Value any
+}
+
+// Literal is an argument value that is passed to KCL as is, KCL decodes it
+// into a number, bool, list or dict when possible and into a string otherwise.
+type Literal string
+
+// Encode returns the argument in the 'name=value' form accepted by KCL.
+func (a Argument) Encode() (string, error) {
+	switch v := a.Value.(type) {
+	case Literal:
+		return fmt.Sprintf("%s=%s", a.Name, string(v)), nil
+	default:
+		// Strings are quoted so that KCL does not decode them into another type.
+		data, err := json.Marshal(v)
+		if err != nil {
+			return "", fmt.Errorf("invalid value for argument '%s': %w", a.Name, err)
+		}
+		return fmt.Sprintf("%s=%s", a.Name, string(data)), nil
+	}
+}
+
+// ParseArgumentValue decodes the value read from a ConfigMap or Secret
+// according to the given ArgumentReference format.
+func ParseArgumentValue(value string, format string) (any, error) {
+	switch format {
+	case "":
+		// Keep the historical behavior of stripping new lines.
+		return Literal(strings.ReplaceAll(value, "\n", "")), nil
+	case v1alpha1.RawArgumentFormat:
+		return value, nil
+	case v1alpha1.JSONArgumentFormat:
+		var v any
+		if err := json.Unmarshal([]byte(value), &v); err != nil {
+			return nil, fmt.Errorf("failed to decode JSON value: %w", err)
+		}
+		return v, nil
+	case v1alpha1.YAMLArgumentFormat:
+		var v any
+		if err := yaml.Unmarshal([]byte(value), &v); err != nil {
+			return nil, fmt.Errorf("failed to decode YAML value: %w", err)
+		}
+		return v, nil
+	default:
+		return nil, fmt.Errorf("unsupported argument format '%s'", format)
+	}
+}
+
+// Arguments is an ordered set of KCL top level arguments, setting a value
+// for an existing name overrides the previous value.
+type Arguments struct {
+	names  []string
+	values map[string]any
+}
+
+// NewArguments returns an empty set of arguments.
+func NewArguments() *Arguments {
+	return &Arguments{values: map[string]any{}}
+}
+
+// Set sets the value of the top level argument with the given name.
+func (a *Arguments) Set(name string, value any) {
+	if _, ok := a.values[name]; !ok {
+		a.names = append(a.names, name)
+	}
+	a.values[name] = value
+}
+
+// SetPath sets the value at the given dot separated path, e.g. 'app.image',
+// where the first segment is the name of the top level argument. Dicts on
+// the path are created when missing and merged otherwise.
+func (a *Arguments) SetPath(path string, value any) error {
+	segments := strings.Split(path, ".")
+	for _, segment := range segments {
+		if segment == "" {
+			return fmt.Errorf("invalid argument path '%s'", path)
+		}
+	}
+	if len(segments) == 1 {
+		a.Set(path, value)
+		return nil
+	}
+
+	root, ok := literalValue(a.values[segments[0]]).(map[string]any)
+	if !ok {
+		root = map[string]any{}
+	}
+	current := root
+	for _, segment := range segments[1 : len(segments)-1] {
+		next, ok := literalValue(current[segment]).(map[string]any)
+		if !ok {
+			next = map[string]any{}
+		}
+		current[segment] = next
+		current = next
+	}
+	current[segments[len(segments)-1]] = literalValue(value)
+	a.Set(segments[0], root)
+	return nil
+}
+
+// List returns the arguments in the order their names were first set.
+func (a *Arguments) List() []Argument {
+	arguments := make([]Argument, 0, len(a.names))
+	for _, name := range a.names {
+		arguments = append(arguments, Argument{Name: name, Value: a.values[name]})
+	}
+	return arguments
+}
+
+// literalValue decodes a Literal the same way KCL does, as JSON when
+// possible and as a string otherwise, so that it can be nested in a dict.
+func literalValue(value any) any {
+	l, ok := value.(Literal)
+	if !ok {
+		return value
+	}
+	var v any
+	if err := json.Unmarshal([]byte(l), &v); err != nil {
+		return string(l)
+	}
+	return v
+}
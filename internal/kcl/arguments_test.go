@@ -0,0 +1,69 @@
+package kcl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/kcl-lang/flux-kcl-controller/api/v1alpha1"
+)
+
+func TestParseArgumentValue(t *testing.T) {
+	pem := "-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n"
+
+	v, err := ParseArgumentValue(pem, "")
+	assert.NoError(t, err)
+	assert.Equal(t, Literal("-----BEGIN CERTIFICATE-----MIIB-----END CERTIFICATE-----"), v)
+
+	v, err = ParseArgumentValue(pem, v1alpha1.RawArgumentFormat)
+	assert.NoError(t, err)
+	assert.Equal(t, pem, v)
+
+	v, err = ParseArgumentValue(`{"replicas": 2, "ports": [80, 443]}`, v1alpha1.JSONArgumentFormat)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]any{"replicas": float64(2), "ports": []any{float64(80), float64(443)}}, v)
+
+	v, err = ParseArgumentValue("replicas: 2\nlabels:\n  app: nginx\n", v1alpha1.YAMLArgumentFormat)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]any{"replicas": float64(2), "labels": map[string]any{"app": "nginx"}}, v)
+
+	_, err = ParseArgumentValue("{", v1alpha1.JSONArgumentFormat)
+	assert.Error(t, err)
+}
+
+func TestArgumentEncode(t *testing.T) {
+	for _, tt := range []struct {
+		arg  Argument
+		want string
+	}{
+		{Argument{Name: "env", Value: Literal(`"prod"`)}, `env="prod"`},
+		{Argument{Name: "replicas", Value: "3"}, `replicas="3"`},
+		{Argument{Name: "cert", Value: "a\nb"}, `cert="a\nb"`},
+		{Argument{Name: "labels", Value: map[string]any{"app": "nginx"}}, `labels={"app":"nginx"}`},
+		{Argument{Name: "ports", Value: []any{float64(80)}}, `ports=[80]`},
+	} {
+		got, err := tt.arg.Encode()
+		assert.NoError(t, err)
+		assert.Equal(t, tt.want, got)
+	}
+}
+
+func TestArgumentsPrecedence(t *testing.T) {
+	args := NewArguments()
+	args.Set("env", Literal("dev"))
+	args.Set("region", "eu-west-1")
+	assert.NoError(t, args.SetPath("app.image", "nginx:1.25"))
+	assert.NoError(t, args.SetPath("app.resources.replicas", float64(2)))
+	args.Set("env", Literal("prod"))
+	assert.NoError(t, args.SetPath("app.image", "nginx:1.26"))
+	assert.Error(t, args.SetPath("app..image", "nginx"))
+
+	assert.Equal(t, []Argument{
+		{Name: "env", Value: Literal("prod")},
+		{Name: "region", Value: "eu-west-1"},
+		{Name: "app", Value: map[string]any{
+			"image":     "nginx:1.26",
+			"resources": map[string]any{"replicas": float64(2)},
+		}},
+	}, args.List())
+}
@@ -0,0 +1,56 @@
+/*
+Copyright 2024 The KCL authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kcl
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// dockerConfig is the docker config.json format read by kpm to authenticate
+// against OCI registries.
+type dockerConfig struct {
+	Auths map[string]json.RawMessage `json:"auths"`
+}
+
+// WriteCredentials merges the given docker configs into a config.json file
+// in dir and returns its path. The entries of later configs take precedence
+// over the ones of earlier configs for the same registry.
+func WriteCredentials(dir string, configs ...[]byte) (string, error) {
+	merged := dockerConfig{Auths: map[string]json.RawMessage{}}
+	for i, data := range configs {
+		var config dockerConfig
+		if err := json.Unmarshal(data, &config); err != nil {
+			return "", fmt.Errorf("invalid docker config at index %d: %w", i, err)
+		}
+		for registry, auth := range config.Auths {
+			merged.Auths[registry] = auth
+		}
+	}
+
+	data, err := json.Marshal(merged)
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return "", fmt.Errorf("failed to write registry credentials: %w", err)
+	}
+	return path, nil
+}
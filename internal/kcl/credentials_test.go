@@ -0,0 +1,45 @@
+/*
+Copyright 2024 The KCL authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kcl
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteCredentials(t *testing.T) {
+	dir := t.TempDir()
+	path, err := WriteCredentials(dir,
+		[]byte(`{"auths":{"registry.local":{"auth":"YTpi"},"ghcr.io":{"auth":"Yzpk"}}}`),
+		[]byte(`{"auths":{"registry.local":{"auth":"ZTpm"}}}`),
+	)
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"auths":{"registry.local":{"auth":"ZTpm"},"ghcr.io":{"auth":"Yzpk"}}}`, string(data))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	_, err = WriteCredentials(dir, []byte("{"))
+	assert.ErrorContains(t, err, "invalid docker config at index 0")
+}
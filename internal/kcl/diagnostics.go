@@ -0,0 +1,118 @@
+/*
+Copyright 2024 The KCL authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kcl
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DefaultDiagnosticKind is the kind of the diagnostics whose error kind
+// could not be determined from the KCL error.
+const DefaultDiagnosticKind = "CompileError"
+
+// Diagnostic is a single error reported by the KCL compiler.
+type Diagnostic struct {
+	File    string
+	Line    int
+	Column  int
+	Kind    string
+	Message string
+}
+
+// String returns the diagnostic in the 'file:line:column kind message' format.
+func (d Diagnostic) String() string {
+	var location string
+	switch {
+	case d.File != "" && d.Column > 0:
+		location = fmt.Sprintf("%s:%d:%d ", d.File, d.Line, d.Column)
+	case d.File != "" && d.Line > 0:
+		location = fmt.Sprintf("%s:%d ", d.File, d.Line)
+	case d.File != "":
+		location = d.File + " "
+	}
+	return strings.TrimSpace(fmt.Sprintf("%s%s %s", location, d.Kind, d.Message))
+}
+
+var (
+	// headerRegexp matches 'error[E2G22]: TypeError', 'EvaluationError' and
+	// 'error[E1001]: InvalidSyntax: expected one of ...' lines.
+	headerRegexp = regexp.MustCompile(`^(?:error(?:\[\w+\])?:\s*)?([A-Z]\w*(?:Error|Warning|Syntax))(?::\s*(.*))?$`)
+	// locationRegexp matches ' --> /path/main.k:12:5' lines.
+	locationRegexp = regexp.MustCompile(`^\s*-->\s*(.+?):(\d+)(?::(\d+))?\s*$`)
+	// markerRegexp matches '  |     ^ expected int, got str' lines.
+	markerRegexp = regexp.MustCompile(`^\s*(?:\|\s*)?\^+\s*(.*)$`)
+)
+
+// ParseDiagnostics extracts the diagnostics from a KCL compile error. File
+// paths under root are reported relative to it. If the error is not in the
+// KCL diagnostic format, a single diagnostic holding the error is returned.
+func ParseDiagnostics(err error, root string) []Diagnostic {
+	if err == nil {
+		return nil
+	}
+
+	var diagnostics []Diagnostic
+	var current *Diagnostic
+	for _, line := range strings.Split(err.Error(), "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if m := headerRegexp.FindStringSubmatch(strings.TrimSpace(line)); m != nil {
+			diagnostics = append(diagnostics, Diagnostic{Kind: m[1], Message: m[2]})
+			current = &diagnostics[len(diagnostics)-1]
+			continue
+		}
+		if current == nil {
+			continue
+		}
+		if m := locationRegexp.FindStringSubmatch(line); m != nil {
+			if current.File != "" {
+				// Another location of the same error, such as the
+				// definition a value is checked against.
+				continue
+			}
+			current.File = relativePath(m[1], root)
+			current.Line, _ = strconv.Atoi(m[2])
+			current.Column, _ = strconv.Atoi(m[3])
+			continue
+		}
+		if m := markerRegexp.FindStringSubmatch(line); m != nil && current.Message == "" {
+			current.Message = m[1]
+		}
+	}
+
+	if len(diagnostics) == 0 {
+		return []Diagnostic{{
+			Kind:    DefaultDiagnosticKind,
+			Message: strings.TrimSpace(err.Error()),
+		}}
+	}
+	return diagnostics
+}
+
+func relativePath(path, root string) string {
+	if root == "" {
+		return path
+	}
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return path
+	}
+	return rel
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2024 The KCL authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kcl
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDiagnostics(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want []Diagnostic
+	}{
+		{
+			name: "type error",
+			err: errors.New(`error[E2G22]: TypeError
+ --> /tmp/run/app/main.k:12:5
+   |
+12 |     replicas: int = "3"
+   |     ^ expected int, got str(3)
+   |
+`),
+			want: []Diagnostic{{File: "app/main.k", Line: 12, Column: 5, Kind: "TypeError", Message: "expected int, got str(3)"}},
+		},
+		{
+			name: "multiple errors with inline message",
+			err: errors.New(`error[E1001]: InvalidSyntax: expected one of ["identifier"] got newline
+ --> /tmp/run/main.k:1:4
+  |
+1 | a =
+  |
+EvaluationError
+ --> /tmp/run/main.k:3
+  |
+3 | assert False
+  | ^ Assertion failure
+  |
+ --> /tmp/run/base.k:1
+`),
+			want: []Diagnostic{
+				{File: "main.k", Line: 1, Column: 4, Kind: "InvalidSyntax", Message: `expected one of ["identifier"] got newline`},
+				{File: "main.k", Line: 3, Kind: "EvaluationError", Message: "Assertion failure"},
+			},
+		},
+		{
+			name: "file outside of the root",
+			err: errors.New(`error[E2L23]: CompileError
+ --> /root/.kcl/kpm/k8s/apps.k:2:1
+  |
+  ^ name 'x' is not defined
+`),
+			want: []Diagnostic{{File: "/root/.kcl/kpm/k8s/apps.k", Line: 2, Column: 1, Kind: "CompileError", Message: "name 'x' is not defined"}},
+		},
+		{
+			name: "unstructured error",
+			err:  errors.New("failed to download dependency 'k8s'\n"),
+			want: []Diagnostic{{Kind: DefaultDiagnosticKind, Message: "failed to download dependency 'k8s'"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, ParseDiagnostics(tt.err, "/tmp/run"))
+		})
+	}
+}
+
+func TestDiagnosticString(t *testing.T) {
+	d := Diagnostic{File: "main.k", Line: 12, Column: 5, Kind: "TypeError", Message: "expected int"}
+	assert.Equal(t, "main.k:12:5 TypeError expected int", d.String())
+	assert.Equal(t, "CompileError boom", Diagnostic{Kind: "CompileError", Message: "boom"}.String())
+}
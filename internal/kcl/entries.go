@@ -0,0 +1,90 @@
+/*
+Copyright 2024 The KCL authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kcl
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	securejoin "github.com/cyphar/filepath-securejoin"
+	pkg "kcl-lang.io/kpm/pkg/package"
+
+	"github.com/kcl-lang/flux-kcl-controller/api/v1alpha1"
+)
+
+// DefaultProfile selects the '[profile]' table of a kcl.mod file, which is
+// the only profile defined by KCL.
+const DefaultProfile = "default"
+
+// modRoot is the KCL variable of the root of the current package, which
+// the entries of a kcl.mod profile can be relative to.
+const modRoot = "${KCL_MOD}"
+
+// ResolveEntries returns the paths of the entry files of the KCL package at
+// pkgPath selected by the files or the profile of the KCLRun config. It
+// returns nil when the package default entries are compiled.
+func ResolveEntries(obj *v1alpha1.KCLRun, pkgPath string) ([]string, error) {
+	if obj == nil || obj.Spec.Config == nil {
+		return nil, nil
+	}
+
+	files := obj.Spec.Config.Files
+	if obj.Spec.Config.Profile != "" {
+		var err error
+		files, err = profileEntries(pkgPath, obj.Spec.Config.Profile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var entries []string
+	for _, file := range files {
+		entry, err := securejoin.SecureJoin(pkgPath, strings.TrimPrefix(file, modRoot))
+		if err != nil {
+			return nil, err
+		}
+		if _, err := os.Stat(entry); err != nil {
+			return nil, fmt.Errorf("KCL entry file '%s' not found: %w", file, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// profileEntries returns the entries of the named profile of the kcl.mod
+// file of the KCL package at pkgPath, loaded with kpm. The entries are
+// relative to the package, or to its root when they start with ${KCL_MOD}.
+func profileEntries(pkgPath, name string) ([]string, error) {
+	if name != DefaultProfile {
+		return nil, fmt.Errorf("profile '%s' not found in kcl.mod, only the '%s' profile is defined by KCL", name, DefaultProfile)
+	}
+	modFile, err := pkg.LoadModFile(pkgPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the profile of kcl.mod: %w", err)
+	}
+	entries := modFile.Profiles.GetEntries()
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("profile '%s' of kcl.mod has no entries", name)
+	}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry, "${") && !strings.HasPrefix(entry, modRoot) {
+			return nil, fmt.Errorf("profile '%s' of kcl.mod has an entry '%s' outside of the package", name, entry)
+		}
+	}
+	return entries, nil
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2024 The KCL authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kcl
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kcl-lang/flux-kcl-controller/api/v1alpha1"
+)
+
+func TestResolveEntries(t *testing.T) {
+	pkgPath := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(pkgPath, "kcl.mod"), []byte(`[package]
+name = "app"
+
+[profile]
+entries = ["base.k", "${KCL_MOD}/prod/main.k"]
+`), 0o600))
+	require.NoError(t, os.MkdirAll(filepath.Join(pkgPath, "prod"), 0o750))
+	for _, name := range []string{"main.k", "base.k", "prod/main.k"} {
+		require.NoError(t, os.WriteFile(filepath.Join(pkgPath, name), []byte("a = 1"), 0o600))
+	}
+
+	newRun := func(config *v1alpha1.ConfigSpec) *v1alpha1.KCLRun {
+		return &v1alpha1.KCLRun{Spec: v1alpha1.KCLRunSpec{Config: config}}
+	}
+
+	entries, err := ResolveEntries(newRun(nil), pkgPath)
+	require.NoError(t, err)
+	assert.Nil(t, entries)
+
+	entries, err = ResolveEntries(newRun(&v1alpha1.ConfigSpec{Files: []string{"prod/main.k"}}), pkgPath)
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(pkgPath, "prod", "main.k")}, entries)
+
+	// The profile entries are relative to the package, or to its root with ${KCL_MOD}.
+	entries, err = ResolveEntries(newRun(&v1alpha1.ConfigSpec{Profile: DefaultProfile}), pkgPath)
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(pkgPath, "base.k"), filepath.Join(pkgPath, "prod", "main.k")}, entries)
+
+	_, err = ResolveEntries(newRun(&v1alpha1.ConfigSpec{Profile: "prod"}), pkgPath)
+	assert.EqualError(t, err, "profile 'prod' not found in kcl.mod, only the 'default' profile is defined by KCL")
+
+	_, err = ResolveEntries(newRun(&v1alpha1.ConfigSpec{Files: []string{"dev.k"}}), pkgPath)
+	assert.ErrorContains(t, err, "KCL entry file 'dev.k' not found")
+
+	// Entry files are scoped to the package path.
+	entries, err = ResolveEntries(newRun(&v1alpha1.ConfigSpec{Files: []string{"../../main.k"}}), pkgPath)
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(pkgPath, "main.k")}, entries)
+}
+
+func TestResolveEntriesProfileErrors(t *testing.T) {
+	obj := &v1alpha1.KCLRun{Spec: v1alpha1.KCLRunSpec{Config: &v1alpha1.ConfigSpec{Profile: DefaultProfile}}}
+	for kclMod, msg := range map[string]string{
+		`[package]
+name = "app"
+`: "profile 'default' of kcl.mod has no entries",
+		`[profile]
+entries = ["${k8s:KCL_MOD}/main.k"]
+`: "profile 'default' of kcl.mod has an entry '${k8s:KCL_MOD}/main.k' outside of the package",
+		`[profile]
+entries = ["${KCL_MOD}/dev.k"]
+`: "KCL entry file '${KCL_MOD}/dev.k' not found",
+	} {
+		pkgPath := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(pkgPath, "kcl.mod"), []byte(kclMod), 0o600))
+		_, err := ResolveEntries(obj, pkgPath)
+		assert.ErrorContains(t, err, msg)
+	}
+}
@@ -1,37 +1,65 @@
 package kcl
 
 import (
-	"fmt"
+	"io"
 	"path/filepath"
+	"strings"
 
 	"github.com/kcl-lang/flux-kcl-controller/api/v1alpha1"
 	"kcl-lang.io/kcl-go/pkg/kcl"
 	"kcl-lang.io/kpm/pkg/client"
 )
 
-// Compile the KCL source code into kubernetes manifests.
-func CompileKclPackage(obj *v1alpha1.KCLRun, pkgPath string, vars map[string]string) (*kcl.KCLResultList, error) {
+// Compile the KCL source code into kubernetes manifests. The kpm messages,
+// e.g. about the downloaded dependencies, are written to logWriter.
+func CompileKclPackage(obj *v1alpha1.KCLRun, pkgPath string, arguments []Argument, modules ModuleOptions, logWriter io.Writer) (*kcl.KCLResultList, error) {
 	cli, _ := client.NewKpmClient()
-	opts := []client.RunOption{}
+	cli.SetLogWriter(logWriter)
+	modules.configure(cli)
+	opts := []client.RunOption{client.WithLogger(logWriter)}
 
 	pkgPath, err := filepath.Abs(pkgPath)
 	if err != nil {
 		return nil, err
 	}
 	opts = append(opts, client.WithWorkDir(pkgPath))
-	// Build KCL top level arguments
+	entries, err := ResolveEntries(obj, pkgPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) > 0 {
+		opts = append(opts, client.WithRunSourceUrls(entries))
+	}
+	// Build KCL top level arguments, the config arguments take precedence
+	// over the ones resolved from the argument references.
+	overridden := map[string]bool{}
+	if obj != nil && obj.Spec.Config != nil {
+		for _, arg := range obj.Spec.Config.Arguments {
+			name, _, _ := strings.Cut(arg, "=")
+			overridden[strings.TrimSpace(name)] = true
+		}
+	}
 	var args []string
-	for k, v := range vars {
-		args = append(args, fmt.Sprintf("%s=%s", k, v))
+	for _, argument := range arguments {
+		if overridden[argument.Name] {
+			continue
+		}
+		arg, err := argument.Encode()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	if obj != nil && obj.Spec.Config != nil {
+		args = append(args, obj.Spec.Config.Arguments...)
 	}
+	opts = append(opts, client.WithArguments(args))
 	if obj != nil {
 		if obj.Spec.Config != nil {
-			args = append(args, obj.Spec.Config.Arguments...)
 			opts = append(
 				opts,
 				client.WithSettingFiles(obj.Spec.Config.Settings),
-				client.WithVendor(obj.Spec.Config.Vendor),
-				client.WithArguments(args),
+				client.WithVendor(useVendor(obj)),
 				client.WithOverrides(obj.Spec.Config.Overrides, false),
 				client.WithPathSelectors(obj.Spec.Config.PathSelectors),
 				client.WithSortKeys(obj.Spec.Config.SortKeys),
@@ -42,3 +70,8 @@ func CompileKclPackage(obj *v1alpha1.KCLRun, pkgPath string, vars map[string]str
 	}
 	return cli.Run(opts...)
 }
+
+// useVendor returns true if the dependencies are resolved from the vendor directory.
+func useVendor(obj *v1alpha1.KCLRun) bool {
+	return obj.Spec.Config != nil && (obj.Spec.Config.Vendor || obj.GetLockMode() == v1alpha1.VendorLockMode)
+}
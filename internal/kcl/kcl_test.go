@@ -1,6 +1,7 @@
 package kcl
 
 import (
+	"io"
 	"testing"
 
 	"github.com/kcl-lang/flux-kcl-controller/api/v1alpha1"
@@ -9,6 +10,6 @@ import (
 
 func TestCompileKclPackage(t *testing.T) {
 	obj := &v1alpha1.KCLRun{}
-	_, err := CompileKclPackage(obj, "testdata/crds", nil)
+	_, err := CompileKclPackage(obj, "testdata/crds", nil, ModuleOptions{}, io.Discard)
 	assert.NoError(t, err)
 }
@@ -0,0 +1,137 @@
+/*
+Copyright 2024 The KCL authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kcl
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/BurntSushi/toml"
+
+	"github.com/kcl-lang/flux-kcl-controller/api/v1alpha1"
+)
+
+// ErrLockMismatch is returned when the resolved KCL module dependencies
+// do not match the kcl.mod.lock of the package.
+var ErrLockMismatch = errors.New("kcl.mod.lock integrity check failed")
+
+// lockedDependency is the part of a kcl.mod.lock dependency identifying
+// the resolved package.
+type lockedDependency struct {
+	Name    string `toml:"name"`
+	Version string `toml:"version"`
+	Sum     string `toml:"sum"`
+}
+
+// lockCheck enforces the kcl.mod.lock mode of a KCLRun around a compilation.
+type lockCheck struct {
+	mode   string
+	path   string
+	locked map[string]lockedDependency
+}
+
+// newLockCheck verifies the preconditions of the lock mode of the KCLRun on
+// the package at pkgPath, and records the locked dependencies to detect
+// changes.
+func newLockCheck(obj *v1alpha1.KCLRun, pkgPath string) (*lockCheck, error) {
+	c := &lockCheck{mode: v1alpha1.UpdateLockMode, path: filepath.Join(pkgPath, "kcl.mod.lock")}
+	if obj != nil {
+		c.mode = obj.GetLockMode()
+	}
+	if c.mode == v1alpha1.UpdateLockMode || !hasRemoteDependencies(filepath.Join(pkgPath, "kcl.mod")) {
+		return c, nil
+	}
+
+	locked, err := readLockedDependencies(c.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%w: kcl.mod.lock not found", ErrLockMismatch)
+		}
+		return nil, err
+	}
+	c.locked = locked
+
+	if c.mode == v1alpha1.VendorLockMode {
+		if _, err := os.Stat(filepath.Join(pkgPath, "vendor")); err != nil {
+			return nil, fmt.Errorf("%w: vendored dependencies not found: %w", ErrDependencyResolution, err)
+		}
+	}
+	return c, nil
+}
+
+// verify returns an error if the name, the version or the checksum of a
+// dependency of the kcl.mod.lock changed during the compilation, or if a
+// dependency was added or removed, in Strict and Vendor modes.
+func (c *lockCheck) verify() error {
+	if c.locked == nil {
+		return nil
+	}
+	resolved, err := readLockedDependencies(c.path)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrLockMismatch, err)
+	}
+
+	keys := make([]string, 0, len(c.locked)+len(resolved))
+	for key := range c.locked {
+		keys = append(keys, key)
+	}
+	for key := range resolved {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range slices.Compact(keys) {
+		locked, isLocked := c.locked[key]
+		dependency, isResolved := resolved[key]
+		switch {
+		case !isLocked:
+			return fmt.Errorf("%w: dependency '%s' is not locked", ErrLockMismatch, key)
+		case !isResolved:
+			return fmt.Errorf("%w: locked dependency '%s' is not resolved", ErrLockMismatch, key)
+		case locked.Name != dependency.Name:
+			return fmt.Errorf("%w: dependency '%s' resolved to package '%s' instead of '%s'", ErrLockMismatch, key, dependency.Name, locked.Name)
+		case locked.Version != dependency.Version:
+			return fmt.Errorf("%w: dependency '%s' resolved to version '%s' instead of '%s'", ErrLockMismatch, key, dependency.Version, locked.Version)
+		case locked.Sum != dependency.Sum:
+			return fmt.Errorf("%w: checksum of dependency '%s' changed from '%s' to '%s'", ErrLockMismatch, key, locked.Sum, dependency.Sum)
+		}
+	}
+	return nil
+}
+
+// readLockedDependencies parses the dependencies of the kcl.mod.lock at path
+// by their key.
+func readLockedDependencies(path string) (map[string]lockedDependency, error) {
+	var lock struct {
+		Dependencies map[string]lockedDependency `toml:"dependencies"`
+	}
+	if _, err := toml.DecodeFile(path, &lock); err != nil {
+		return nil, err
+	}
+	if lock.Dependencies == nil {
+		lock.Dependencies = map[string]lockedDependency{}
+	}
+	return lock.Dependencies, nil
+}
+
+// IsLockMismatchError returns true if the compile error was caused by
+// dependencies that do not match the kcl.mod.lock.
+func IsLockMismatchError(err error) bool {
+	return errors.Is(err, ErrLockMismatch)
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2024 The KCL authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kcl
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kcl-lang/flux-kcl-controller/api/v1alpha1"
+)
+
+func newLockModeRun(mode string) *v1alpha1.KCLRun {
+	return &v1alpha1.KCLRun{Spec: v1alpha1.KCLRunSpec{Config: &v1alpha1.ConfigSpec{LockMode: mode}}}
+}
+
+func TestLockCheck(t *testing.T) {
+	pkgPath := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(pkgPath, "kcl.mod"), []byte(`[dependencies]
+k8s = "1.28"
+`), 0o600))
+
+	// The lock file is only required in Strict and Vendor modes.
+	_, err := newLockCheck(newLockModeRun(v1alpha1.UpdateLockMode), pkgPath)
+	assert.NoError(t, err)
+	_, err = newLockCheck(newLockModeRun(v1alpha1.StrictLockMode), pkgPath)
+	assert.ErrorIs(t, err, ErrLockMismatch)
+
+	lockPath := filepath.Join(pkgPath, "kcl.mod.lock")
+	require.NoError(t, os.WriteFile(lockPath, []byte(`[dependencies]
+  [dependencies.k8s]
+    name = "k8s"
+    full_name = "k8s_1.28"
+    version = "1.28"
+    sum = "aTxPUi/o1uN/ynvUVgDXYtLfJD0GhxQCxYC5vVNfwGQ="
+    reg = "ghcr.io"
+    repo = "kcl-lang/k8s"
+    oci_tag = "1.28"
+`), 0o600))
+
+	_, err = newLockCheck(newLockModeRun(v1alpha1.VendorLockMode), pkgPath)
+	assert.ErrorIs(t, err, ErrDependencyResolution)
+
+	lock, err := newLockCheck(newLockModeRun(v1alpha1.StrictLockMode), pkgPath)
+	require.NoError(t, err)
+	assert.NoError(t, lock.verify())
+
+	// The formatting and the sources of the dependencies are not verified,
+	// they are rewritten by the module rewrites.
+	require.NoError(t, os.WriteFile(lockPath, []byte(`[dependencies.k8s]
+name = "k8s"
+full_name = "k8s_1.28"
+version = "1.28"
+sum = "aTxPUi/o1uN/ynvUVgDXYtLfJD0GhxQCxYC5vVNfwGQ="
+reg = "mirror.local"
+repo = "kcl/k8s"
+oci_tag = "1.28"
+`), 0o600))
+	assert.NoError(t, lock.verify())
+
+	for name, lockFile := range map[string]string{
+		"version": `[dependencies.k8s]
+name = "k8s"
+version = "1.29"
+sum = "aTxPUi/o1uN/ynvUVgDXYtLfJD0GhxQCxYC5vVNfwGQ="
+`,
+		"sum": `[dependencies.k8s]
+name = "k8s"
+version = "1.28"
+sum = "OTBzEbFT5bULgg1Bpp4AvzvXbNYW5rlHMqoVrCZzJUA="
+`,
+		"name": `[dependencies.k8s]
+name = "kubernetes"
+version = "1.28"
+sum = "aTxPUi/o1uN/ynvUVgDXYtLfJD0GhxQCxYC5vVNfwGQ="
+`,
+		"added": `[dependencies.k8s]
+name = "k8s"
+version = "1.28"
+sum = "aTxPUi/o1uN/ynvUVgDXYtLfJD0GhxQCxYC5vVNfwGQ="
+[dependencies.helloworld]
+name = "helloworld"
+version = "0.1.0"
+sum = "PN0OMEV9M8VGFn1CtA/T3bcgZmMJmOo+RkBBgNT2OQI="
+`,
+		"removed": ``,
+	} {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, os.WriteFile(lockPath, []byte(lockFile), 0o600))
+			err := lock.verify()
+			assert.ErrorIs(t, err, ErrLockMismatch)
+			assert.True(t, IsLockMismatchError(err))
+		})
+	}
+}
+
+func TestIsLockMismatchError(t *testing.T) {
+	assert.True(t, IsLockMismatchError(fmt.Errorf("%w: kcl.mod.lock not found", ErrLockMismatch)))
+	assert.False(t, IsLockMismatchError(errors.New("failed to pull 'k8s': checksum mismatch")))
+	assert.False(t, IsLockMismatchError(errors.New("EvaluationError")))
+	assert.False(t, IsLockMismatchError(nil))
+}
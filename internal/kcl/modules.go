@@ -0,0 +1,304 @@
+/*
+Copyright 2024 The KCL authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kcl
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"kcl-lang.io/kpm/pkg/client"
+	"oras.land/oras-go/v2/errdef"
+	"oras.land/oras-go/v2/registry/remote/errcode"
+
+	"github.com/kcl-lang/flux-kcl-controller/api/v1alpha1"
+)
+
+// ModuleOptions configures how the KCL module dependencies are resolved.
+type ModuleOptions struct {
+	// Registry is the OCI registry the dependencies without an explicit
+	// source are pulled from, the kpm default is used when empty.
+	Registry string `json:"registry,omitempty"`
+
+	// Repository is the repository in Registry the dependencies are pulled
+	// from, the kpm default is used when empty.
+	Repository string `json:"repository,omitempty"`
+
+	// PlainHTTP pulls the dependencies from Registry over plain HTTP.
+	PlainHTTP bool `json:"plainHTTP,omitempty"`
+
+	// Rewrites are the rules rewriting the sources of the dependencies,
+	// the first matching rule applies.
+	Rewrites []v1alpha1.ModuleRewrite `json:"rewrites,omitempty"`
+
+	// HomePath is the local module cache directory holding the
+	// pre-populated dependencies, the kpm default is used when empty.
+	HomePath string `json:"homePath,omitempty"`
+
+	// CredentialsFile is the docker config.json file holding the registry
+	// credentials, the kpm default is used when empty.
+	CredentialsFile string `json:"credentialsFile,omitempty"`
+}
+
+// WithMirror returns the options with the module mirror of the given
+// KCLRun, if any, taking precedence over the controller defaults.
+func (o ModuleOptions) WithMirror(obj *v1alpha1.KCLRun) ModuleOptions {
+	if obj == nil || obj.Spec.Config == nil || obj.Spec.Config.ModuleMirror == nil {
+		return o
+	}
+	mirror := obj.Spec.Config.ModuleMirror
+	if mirror.Registry != "" {
+		o.Registry = mirror.Registry
+		o.Repository = mirror.Repository
+		o.PlainHTTP = mirror.PlainHTTP
+	}
+	if len(mirror.Rewrites) > 0 {
+		o.Rewrites = append(append([]v1alpha1.ModuleRewrite{}, mirror.Rewrites...), o.Rewrites...)
+	}
+	return o
+}
+
+// configure applies the options to the kpm client.
+func (o ModuleOptions) configure(cli *client.KpmClient) {
+	if o.HomePath != "" {
+		cli.SetHomePath(o.HomePath)
+	}
+	settings := cli.GetSettings()
+	if o.CredentialsFile != "" {
+		settings.CredentialsFile = o.CredentialsFile
+	}
+	registry, repository := o.defaultSource()
+	if registry != "" {
+		settings.Conf.DefaultOciRegistry = registry
+		settings.Conf.DefaultOciPlainHttp = o.PlainHTTP
+	}
+	if repository != "" {
+		settings.Conf.DefaultOciRepo = repository
+	}
+}
+
+// credentialsFile returns the docker config file authenticating against the
+// registries, the default of kpm when CredentialsFile is empty.
+func (o ModuleOptions) credentialsFile() (string, error) {
+	if o.CredentialsFile != "" {
+		return o.CredentialsFile, nil
+	}
+	cli, err := client.NewKpmClient()
+	if err != nil {
+		return "", fmt.Errorf("failed to create the kpm client: %w", err)
+	}
+	o.configure(cli)
+	return cli.GetSettings().CredentialsFile, nil
+}
+
+const (
+	// defaultRegistry is the kpm default OCI registry.
+	defaultRegistry = "ghcr.io"
+
+	// defaultRepository is the kpm default OCI repository.
+	defaultRepository = "kcl-lang"
+)
+
+// defaultSource returns the registry and the repository the dependencies
+// without an explicit source are pulled from, after applying the rewrites.
+// They are empty when the kpm defaults are used.
+func (o ModuleOptions) defaultSource() (string, string) {
+	registry, repository := o.Registry, o.Repository
+	if registry == "" {
+		registry = defaultRegistry
+	}
+	if repository == "" {
+		repository = defaultRepository
+	}
+	if rewritten, ok := o.rewrite(registry + "/" + repository); ok {
+		registry, repository, _ = strings.Cut(rewritten, "/")
+		return registry, repository
+	}
+	return o.Registry, o.Repository
+}
+
+// ParseModuleRewrites parses the '<from>=<to>' module rewrite rules.
+func ParseModuleRewrites(rules []string) ([]v1alpha1.ModuleRewrite, error) {
+	var rewrites []v1alpha1.ModuleRewrite
+	for _, rule := range rules {
+		from, to, ok := strings.Cut(rule, "=")
+		if !ok || from == "" || to == "" {
+			return nil, fmt.Errorf("invalid module rewrite '%s', must be '<from>=<to>'", rule)
+		}
+		rewrites = append(rewrites, v1alpha1.ModuleRewrite{From: from, To: to})
+	}
+	return rewrites, nil
+}
+
+// rewrite returns the source rewritten by the first matching rule, keeping
+// its URL scheme, and whether a rule matched.
+func (o ModuleOptions) rewrite(source string) (string, bool) {
+	scheme, rest, found := strings.Cut(source, "://")
+	if !found {
+		scheme, rest = "", source
+	}
+	for _, r := range o.Rewrites {
+		from := strings.TrimSuffix(r.From, "/")
+		if rest != from && !strings.HasPrefix(rest, from+"/") {
+			continue
+		}
+		rest = strings.TrimSuffix(r.To, "/") + strings.TrimPrefix(rest, from)
+		if found {
+			return scheme + "://" + rest, true
+		}
+		return rest, true
+	}
+	return source, false
+}
+
+// sourceRegexp matches the OCI and Git sources of the dependencies in
+// kcl.mod and kcl.mod.lock, e.g. 'oci = "oci://ghcr.io/kcl-lang/k8s"'.
+var sourceRegexp = regexp.MustCompile(`\b(oci|git|url)(\s*=\s*)"([^"]*)"`)
+
+// registryRegexp matches the registry and the repository of the OCI
+// dependencies in kcl.mod.lock, e.g. 'reg = "ghcr.io"'.
+var registryRegexp = regexp.MustCompile(`^(\s*)(reg|repo)(\s*=\s*)"([^"]*)"(.*)$`)
+
+// rewriteDependencies rewrites in place the sources of the dependencies
+// of the kcl.mod and the kcl.mod.lock files of the package at pkgPath.
+func (o ModuleOptions) rewriteDependencies(pkgPath string) error {
+	if len(o.Rewrites) == 0 {
+		return nil
+	}
+	for _, name := range []string{"kcl.mod", "kcl.mod.lock"} {
+		path := filepath.Join(pkgPath, name)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		rewritten := o.rewriteSources(string(data))
+		if name == "kcl.mod.lock" {
+			rewritten = o.rewriteRegistries(rewritten)
+		}
+		if rewritten == string(data) {
+			continue
+		}
+		if err := os.WriteFile(path, []byte(rewritten), 0o600); err != nil {
+			return fmt.Errorf("failed to rewrite the dependencies of %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// rewriteSources rewrites the 'oci', 'git' and 'url' sources of the content.
+func (o ModuleOptions) rewriteSources(content string) string {
+	return sourceRegexp.ReplaceAllStringFunc(content, func(match string) string {
+		m := sourceRegexp.FindStringSubmatch(match)
+		rewritten, _ := o.rewrite(m[3])
+		return fmt.Sprintf(`%s%s"%s"`, m[1], m[2], rewritten)
+	})
+}
+
+// rewriteRegistries rewrites the 'reg' and 'repo' pairs of the tables of
+// the kcl.mod.lock content.
+func (o ModuleOptions) rewriteRegistries(content string) string {
+	lines := strings.Split(content, "\n")
+	reg, repo := -1, -1
+	flush := func() {
+		defer func() { reg, repo = -1, -1 }()
+		if reg < 0 || repo < 0 {
+			return
+		}
+		r := registryRegexp.FindStringSubmatch(lines[reg])
+		p := registryRegexp.FindStringSubmatch(lines[repo])
+		rewritten, ok := o.rewrite(r[4] + "/" + p[4])
+		if !ok {
+			return
+		}
+		registry, repository, _ := strings.Cut(rewritten, "/")
+		lines[reg] = fmt.Sprintf(`%s%s%s"%s"%s`, r[1], r[2], r[3], registry, r[5])
+		lines[repo] = fmt.Sprintf(`%s%s%s"%s"%s`, p[1], p[2], p[3], repository, p[5])
+	}
+	for i, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "[") {
+			flush()
+			continue
+		}
+		if m := registryRegexp.FindStringSubmatch(line); m != nil {
+			if m[2] == "reg" {
+				reg = i
+			} else {
+				repo = i
+			}
+		}
+	}
+	flush()
+	return strings.Join(lines, "\n")
+}
+
+// ErrDependencyResolution is returned when the KCL module dependencies
+// cannot be resolved.
+var ErrDependencyResolution = errors.New("KCL dependency resolution failed")
+
+// hasRemoteDependencies returns true if the kcl.mod file declares
+// dependencies other than local paths, either inline or as sub-tables.
+func hasRemoteDependencies(kclMod string) bool {
+	var mod struct {
+		Dependencies map[string]any `toml:"dependencies"`
+	}
+	if _, err := toml.DecodeFile(kclMod, &mod); err != nil {
+		return false
+	}
+	for _, dependency := range mod.Dependencies {
+		if source, ok := dependency.(map[string]any); ok {
+			if _, local := source["path"]; local {
+				continue
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// IsDependencyResolutionError returns true if the compile error was caused
+// by a KCL module dependency that could not be downloaded, either because
+// the registry could not be reached or because it rejected the request.
+func IsDependencyResolutionError(err error) bool {
+	if errors.Is(err, ErrDependencyResolution) || errors.Is(err, errdef.ErrNotFound) {
+		return true
+	}
+	var netErr net.Error
+	var respErr *errcode.ErrorResponse
+	return errors.As(err, &netErr) || errors.As(err, &respErr)
+}
+
+// resolutionError is a dependency resolution error reported by the sandbox,
+// whose type is lost when crossing the process boundary.
+type resolutionError struct {
+	msg string
+}
+
+func (e *resolutionError) Error() string {
+	return e.msg
+}
+
+func (e *resolutionError) Is(target error) bool {
+	return target == ErrDependencyResolution
+}
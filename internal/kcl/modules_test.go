@@ -0,0 +1,191 @@
+/*
+Copyright 2024 The KCL authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kcl
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"oras.land/oras-go/v2/errdef"
+	"oras.land/oras-go/v2/registry/remote/errcode"
+
+	"github.com/kcl-lang/flux-kcl-controller/api/v1alpha1"
+)
+
+func TestModuleOptionsWithMirror(t *testing.T) {
+	defaults := ModuleOptions{Registry: "registry.local", Repository: "kcl"}
+	assert.Equal(t, defaults, defaults.WithMirror(&v1alpha1.KCLRun{}))
+
+	obj := &v1alpha1.KCLRun{Spec: v1alpha1.KCLRunSpec{Config: &v1alpha1.ConfigSpec{
+		ModuleMirror: &v1alpha1.ModuleMirror{Registry: "mirror.local:5000", PlainHTTP: true},
+	}}}
+	assert.Equal(t, ModuleOptions{Registry: "mirror.local:5000", PlainHTTP: true}, defaults.WithMirror(obj))
+
+	// The rewrites of the KCLRun take precedence over the controller ones.
+	defaults.Rewrites = []v1alpha1.ModuleRewrite{{From: "ghcr.io", To: "registry.local"}}
+	obj.Spec.Config.ModuleMirror = &v1alpha1.ModuleMirror{
+		Rewrites: []v1alpha1.ModuleRewrite{{From: "ghcr.io/org", To: "mirror.local/org"}},
+	}
+	assert.Equal(t, ModuleOptions{Registry: "registry.local", Repository: "kcl", Rewrites: []v1alpha1.ModuleRewrite{
+		{From: "ghcr.io/org", To: "mirror.local/org"},
+		{From: "ghcr.io", To: "registry.local"},
+	}}, defaults.WithMirror(obj))
+}
+
+func TestHasRemoteDependencies(t *testing.T) {
+	kclMod := filepath.Join(t.TempDir(), "kcl.mod")
+	tests := []struct {
+		content string
+		want    bool
+	}{
+		{"[package]\nname = \"app\"\n", false},
+		{"[dependencies]\nlib = { path = \"../lib\" }\n", false},
+		{"[dependencies]\nk8s = \"1.28\"\n", true},
+		{"[dependencies]\nkonfig = { oci = \"oci://ghcr.io/kcl-lang/konfig\", tag = \"v0.4.0\" }\n", true},
+		{"[dependencies.lib]\npath = \"../lib\"\n", false},
+		{"[dependencies.helloworld]\ngit = \"https://github.com/kcl-lang/flask-demo-kcl-manifests\"\n", true},
+	}
+	for _, tt := range tests {
+		require.NoError(t, os.WriteFile(kclMod, []byte(tt.content), 0o600))
+		assert.Equal(t, tt.want, hasRemoteDependencies(kclMod), tt.content)
+	}
+}
+
+func TestParseModuleRewrites(t *testing.T) {
+	rewrites, err := ParseModuleRewrites([]string{"ghcr.io/kcl-lang=registry.local/kcl"})
+	require.NoError(t, err)
+	assert.Equal(t, []v1alpha1.ModuleRewrite{{From: "ghcr.io/kcl-lang", To: "registry.local/kcl"}}, rewrites)
+
+	_, err = ParseModuleRewrites([]string{"ghcr.io"})
+	assert.ErrorContains(t, err, "invalid module rewrite 'ghcr.io'")
+}
+
+func TestModuleOptionsRewrite(t *testing.T) {
+	o := ModuleOptions{Rewrites: []v1alpha1.ModuleRewrite{
+		{From: "ghcr.io/kcl-lang", To: "registry.local:5000/kcl"},
+		{From: "github.com/org/", To: "git.local/mirror"},
+	}}
+	tests := []struct {
+		source  string
+		want    string
+		matched bool
+	}{
+		{"oci://ghcr.io/kcl-lang/k8s", "oci://registry.local:5000/kcl/k8s", true},
+		{"ghcr.io/kcl-lang", "registry.local:5000/kcl", true},
+		{"oci://ghcr.io/kcl-lang-fork/k8s", "oci://ghcr.io/kcl-lang-fork/k8s", false},
+		{"https://github.com/org/repo", "https://git.local/mirror/repo", true},
+		{"https://gitlab.com/org/repo", "https://gitlab.com/org/repo", false},
+	}
+	for _, tt := range tests {
+		got, matched := o.rewrite(tt.source)
+		assert.Equal(t, tt.want, got, tt.source)
+		assert.Equal(t, tt.matched, matched, tt.source)
+	}
+
+	// The dependencies without an explicit source are rewritten too.
+	registry, repository := o.defaultSource()
+	assert.Equal(t, "registry.local:5000", registry)
+	assert.Equal(t, "kcl", repository)
+	registry, repository = ModuleOptions{}.defaultSource()
+	assert.Empty(t, registry)
+	assert.Empty(t, repository)
+}
+
+func TestRewriteDependencies(t *testing.T) {
+	pkgPath := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(pkgPath, "kcl.mod"), []byte(`[package]
+name = "app"
+
+[dependencies]
+k8s = "1.28"
+konfig = { oci = "oci://ghcr.io/kcl-lang/konfig", tag = "v0.4.0" }
+
+[dependencies.demo]
+git = "https://github.com/org/demo"
+tag = "v0.1.0"
+`), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(pkgPath, "kcl.mod.lock"), []byte(`[dependencies]
+  [dependencies.demo]
+    name = "demo"
+    url = "https://github.com/org/demo"
+    git_tag = "v0.1.0"
+  [dependencies.konfig]
+    name = "konfig"
+    reg = "ghcr.io"
+    repo = "kcl-lang/konfig"
+    oci_tag = "v0.4.0"
+  [dependencies.other]
+    name = "other"
+    reg = "docker.io"
+    repo = "org/other"
+`), 0o600))
+
+	o := ModuleOptions{Rewrites: []v1alpha1.ModuleRewrite{
+		{From: "ghcr.io/kcl-lang", To: "registry.local:5000/kcl"},
+		{From: "github.com/org", To: "git.local/mirror"},
+	}}
+	require.NoError(t, o.rewriteDependencies(pkgPath))
+
+	kclMod, err := os.ReadFile(filepath.Join(pkgPath, "kcl.mod"))
+	require.NoError(t, err)
+	assert.Contains(t, string(kclMod), `konfig = { oci = "oci://registry.local:5000/kcl/konfig", tag = "v0.4.0" }`)
+	assert.Contains(t, string(kclMod), `git = "https://git.local/mirror/demo"`)
+	assert.Contains(t, string(kclMod), `k8s = "1.28"`)
+
+	lock, err := os.ReadFile(filepath.Join(pkgPath, "kcl.mod.lock"))
+	require.NoError(t, err)
+	assert.Contains(t, string(lock), `    url = "https://git.local/mirror/demo"`)
+	assert.Contains(t, string(lock), "    reg = \"registry.local:5000\"\n    repo = \"kcl/konfig\"")
+	assert.Contains(t, string(lock), "    reg = \"docker.io\"\n    repo = \"org/other\"")
+}
+
+func TestIsDependencyResolutionError(t *testing.T) {
+	dnsErr := &net.DNSError{Err: "no such host", Name: "ghcr.io", IsNotFound: true}
+	opErr := &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+	urlErr := &url.Error{Op: "Get", URL: "https://ghcr.io/v2/", Err: opErr}
+	respErr := &errcode.ErrorResponse{Method: http.MethodGet, StatusCode: http.StatusUnauthorized}
+
+	for _, err := range []error{
+		ErrDependencyResolution,
+		fmt.Errorf("%w: vendored dependencies not found", ErrDependencyResolution),
+		&resolutionError{msg: "failed to download 'k8s'"},
+		fmt.Errorf("failed to download 'k8s': %w", dnsErr),
+		fmt.Errorf("failed to download 'k8s': %w", urlErr),
+		fmt.Errorf("failed to pull 'k8s': %w", respErr),
+		fmt.Errorf("failed to resolve 'k8s': %w", errdef.ErrNotFound),
+	} {
+		assert.True(t, IsDependencyResolutionError(err), "%v", err)
+	}
+	for _, err := range []error{
+		nil,
+		errors.New("EvaluationError"),
+		errors.New("failed to download 'k8s': dial tcp: lookup ghcr.io: no such host"),
+		fmt.Errorf("failed to read kcl.mod: %w", os.ErrNotExist),
+		ErrCompileTimeout,
+	} {
+		assert.False(t, IsDependencyResolutionError(err), "%v", err)
+	}
+}
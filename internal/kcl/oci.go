@@ -0,0 +1,196 @@
+/*
+Copyright 2024 The KCL authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kcl
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/blang/semver/v4"
+	"github.com/fluxcd/pkg/tar"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/registry/remote"
+
+	"github.com/kcl-lang/flux-kcl-controller/api/v1alpha1"
+	"github.com/kcl-lang/flux-kcl-controller/internal/oci"
+)
+
+// DefaultModuleTag is the tag of the KCL package pulled when the module
+// source specifies neither a tag, a semver range nor a digest.
+const DefaultModuleTag = "latest"
+
+// maxModuleLayerSize is the maximum size in bytes of a KCL package layer.
+const maxModuleLayerSize = 100 << 20
+
+// ResolvedModule is a KCL package of an OCI registry resolved to the
+// digest of its manifest.
+type ResolvedModule struct {
+	// Tag is the resolved tag, empty when the module is pinned by digest.
+	Tag string
+
+	// Digest is the digest of the KCL package manifest.
+	Digest string
+}
+
+// Revision returns the revision of the module in the format of the
+// OCIRepository artifacts, '<tag>@<digest>' or '<digest>'.
+func (m *ResolvedModule) Revision() string {
+	if m.Tag == "" {
+		return m.Digest
+	}
+	return m.Tag + "@" + m.Digest
+}
+
+// ResolveModule resolves the tag, the highest tag in the semver range, or
+// the digest of the module source to the digest of the KCL package manifest.
+// The refused tags are never selected from the semver range. The module is
+// pulled as kpm pulls the dependencies, through the mirror and with the
+// credentials of the module options.
+func ResolveModule(ctx context.Context, src *v1alpha1.ModuleSource, opts ModuleOptions, refused []string) (*ResolvedModule, error) {
+	repo, err := newRepository(src, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	module := &ResolvedModule{}
+	reference := src.Digest
+	if reference == "" {
+		module.Tag = src.Tag
+		if src.SemVer != "" {
+			var tags []string
+			if err := repo.Tags(ctx, "", func(page []string) error {
+				tags = append(tags, page...)
+				return nil
+			}); err != nil {
+				return nil, fmt.Errorf("failed to list the tags of '%s': %w", src.URL, err)
+			}
+			if module.Tag, err = latestTag(tags, src.SemVer, refused); err != nil {
+				return nil, err
+			}
+		}
+		if module.Tag == "" {
+			module.Tag = DefaultModuleTag
+		}
+		reference = module.Tag
+	}
+
+	desc, err := repo.Resolve(ctx, reference)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve '%s' of '%s': %w", reference, src.URL, err)
+	}
+	module.Digest = desc.Digest.String()
+	return module, nil
+}
+
+// PullModule downloads the KCL package of the module source with the given
+// manifest digest through the mirror of the module options, and extracts
+// its layers into dir.
+func PullModule(ctx context.Context, src *v1alpha1.ModuleSource, digest string, opts ModuleOptions, dir string) error {
+	repo, err := newRepository(src, opts)
+	if err != nil {
+		return err
+	}
+
+	_, data, err := oras.FetchBytes(ctx, repo, digest, oras.DefaultFetchBytesOptions)
+	if err != nil {
+		return fmt.Errorf("failed to fetch the manifest '%s' of '%s': %w", digest, src.URL, err)
+	}
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return fmt.Errorf("invalid manifest '%s' of '%s': %w", digest, src.URL, err)
+	}
+	if len(manifest.Layers) == 0 {
+		return fmt.Errorf("manifest '%s' of '%s' has no layers", digest, src.URL)
+	}
+
+	for _, layer := range manifest.Layers {
+		if layer.Size > maxModuleLayerSize {
+			return fmt.Errorf("layer '%s' of '%s' exceeds the maximum size of %d bytes", layer.Digest, src.URL, maxModuleLayerSize)
+		}
+		rc, err := repo.Fetch(ctx, layer)
+		if err != nil {
+			return fmt.Errorf("failed to fetch the layer '%s' of '%s': %w", layer.Digest, src.URL, err)
+		}
+		// ReadAll verifies the size and the digest of the layer.
+		data, err := content.ReadAll(rc, layer)
+		rc.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read the layer '%s' of '%s': %w", layer.Digest, src.URL, err)
+		}
+
+		opts := []tar.TarOption{tar.WithMaxUntarSize(maxModuleLayerSize)}
+		if !strings.HasSuffix(layer.MediaType, "gzip") {
+			opts = append(opts, tar.WithSkipGzip())
+		}
+		if err := tar.Untar(bytes.NewReader(data), dir, opts...); err != nil {
+			return fmt.Errorf("failed to extract the layer '%s' of '%s': %w", layer.Digest, src.URL, err)
+		}
+	}
+	return nil
+}
+
+// newRepository returns the client of the OCI repository of the module
+// source, rewritten by the module rewrites. The mirror registry is reached
+// over plain HTTP when configured so, and the registries are authenticated
+// with the credentials file of kpm.
+func newRepository(src *v1alpha1.ModuleSource, opts ModuleOptions) (*remote.Repository, error) {
+	url, _ := opts.rewrite(src.URL)
+	plainHTTP := src.PlainHTTP
+	if opts.Registry != "" && opts.PlainHTTP {
+		registry, _, _ := strings.Cut(strings.TrimPrefix(url, "oci://"), "/")
+		plainHTTP = plainHTTP || registry == opts.Registry
+	}
+	credentialsFile, err := opts.credentialsFile()
+	if err != nil {
+		return nil, err
+	}
+	return oci.NewRepository(url, plainHTTP, credentialsFile)
+}
+
+// latestTag returns the highest of the tags which are semantic versions
+// in the given range and are not refused. Pre-releases are only selected
+// when the range contains a pre-release version.
+func latestTag(tags []string, constraint string, refused []string) (string, error) {
+	inRange, err := semver.ParseRange(constraint)
+	if err != nil {
+		return "", fmt.Errorf("invalid semver range '%s': %w", constraint, err)
+	}
+	withPre := strings.Contains(constraint, "-")
+
+	var latest *semver.Version
+	var tag string
+	for _, t := range tags {
+		v, err := semver.ParseTolerant(t)
+		if err != nil || (len(v.Pre) > 0 && !withPre) || !inRange(v) || slices.Contains(refused, t) {
+			continue
+		}
+		if latest == nil || v.GT(*latest) {
+			latest = &v
+			tag = t
+		}
+	}
+	if latest == nil {
+		return "", fmt.Errorf("no tag matches the semver range '%s'", constraint)
+	}
+	return tag, nil
+}
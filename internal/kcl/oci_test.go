@@ -0,0 +1,109 @@
+/*
+Copyright 2024 The KCL authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kcl
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kcl-lang/flux-kcl-controller/api/v1alpha1"
+)
+
+func TestModuleLatestTag(t *testing.T) {
+	tags := []string{"latest", "0.1.0", "v0.2.0", "0.10.1", "1.0.0-rc.1", "1.0.0", "2.0.0"}
+
+	tag, err := latestTag(tags, ">=0.1.0 <1.0.0", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "0.10.1", tag)
+
+	tag, err = latestTag(tags, "<2.0.0", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "1.0.0", tag)
+
+	tag, err = latestTag(tags, ">=0.2.0 <0.3.0", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "v0.2.0", tag)
+
+	tag, err = latestTag(tags, "0.x", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "0.10.1", tag)
+
+	tag, err = latestTag(tags, "<1.0.0 || >=2.0.0", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "2.0.0", tag)
+
+	tag, err = latestTag(tags, ">=1.0.0-rc.0 <1.0.0", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "1.0.0-rc.1", tag)
+
+	tag, err = latestTag(tags, ">=0.1.0 <2.0.0", []string{"1.0.0"})
+	require.NoError(t, err)
+	assert.Equal(t, "0.10.1", tag)
+
+	_, err = latestTag(tags, ">=3.0.0", nil)
+	assert.ErrorContains(t, err, "no tag matches")
+
+	// The versions of the range are parsed as is, they must be complete.
+	for _, constraint := range []string{"not a range", ">=0.2 <0.3", ">=v1.0.0"} {
+		_, err = latestTag(tags, constraint, nil)
+		assert.ErrorContains(t, err, "invalid semver range", constraint)
+	}
+}
+
+func TestModuleRevision(t *testing.T) {
+	digest := "sha256:6d8e3a6d0c0c5e5f1a6e7e4e2d0b6b3b0f3c2f1b9e0b1a2c3d4e5f60718293a4"
+	assert.Equal(t, "0.1.0@"+digest, (&ResolvedModule{Tag: "0.1.0", Digest: digest}).Revision())
+	assert.Equal(t, digest, (&ResolvedModule{Digest: digest}).Revision())
+}
+
+func TestModuleRepository(t *testing.T) {
+	credentialsFile := filepath.Join(t.TempDir(), "config.json")
+	repo, err := newRepository(&v1alpha1.ModuleSource{URL: "oci://registry.local:5000/kcl/app", PlainHTTP: true},
+		ModuleOptions{CredentialsFile: credentialsFile})
+	require.NoError(t, err)
+	assert.Equal(t, "registry.local:5000", repo.Reference.Registry)
+	assert.Equal(t, "kcl/app", repo.Reference.Repository)
+	assert.True(t, repo.PlainHTTP)
+
+	_, err = newRepository(&v1alpha1.ModuleSource{URL: "ghcr.io/kcl/app"}, ModuleOptions{CredentialsFile: credentialsFile})
+	assert.ErrorContains(t, err, "must start with 'oci://'")
+}
+
+func TestModuleRepositoryMirror(t *testing.T) {
+	opts := ModuleOptions{
+		Registry:        "mirror.local:5000",
+		PlainHTTP:       true,
+		Rewrites:        []v1alpha1.ModuleRewrite{{From: "ghcr.io/kcl-lang", To: "mirror.local:5000/kcl"}},
+		CredentialsFile: filepath.Join(t.TempDir(), "config.json"),
+	}
+
+	// The module is pulled from the mirror over plain HTTP, as its dependencies.
+	repo, err := newRepository(&v1alpha1.ModuleSource{URL: "oci://ghcr.io/kcl-lang/helloworld"}, opts)
+	require.NoError(t, err)
+	assert.Equal(t, "mirror.local:5000", repo.Reference.Registry)
+	assert.Equal(t, "kcl/helloworld", repo.Reference.Repository)
+	assert.True(t, repo.PlainHTTP)
+
+	repo, err = newRepository(&v1alpha1.ModuleSource{URL: "oci://docker.io/org/app"}, opts)
+	require.NoError(t, err)
+	assert.Equal(t, "registry-1.docker.io", repo.Reference.Host())
+	assert.Equal(t, "org/app", repo.Reference.Repository)
+	assert.False(t, repo.PlainHTTP)
+}
@@ -0,0 +1,46 @@
+//go:build linux
+
+/*
+Copyright 2024 The KCL authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kcl
+
+import (
+	"math"
+	"runtime/debug"
+	"syscall"
+	"time"
+)
+
+// setResourceLimits caps the memory and CPU time of the current process.
+// RLIMIT_DATA is used rather than RLIMIT_AS since the Go runtime reserves
+// much more address space than it uses.
+func setResourceLimits(maxMemory int64, maxCPUTime time.Duration) error {
+	if maxMemory > 0 {
+		debug.SetMemoryLimit(maxMemory)
+		limit := uint64(maxMemory)
+		if err := syscall.Setrlimit(syscall.RLIMIT_DATA, &syscall.Rlimit{Cur: limit, Max: limit}); err != nil {
+			return err
+		}
+	}
+	if maxCPUTime > 0 {
+		seconds := uint64(math.Ceil(maxCPUTime.Seconds()))
+		if err := syscall.Setrlimit(syscall.RLIMIT_CPU, &syscall.Rlimit{Cur: seconds, Max: seconds}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
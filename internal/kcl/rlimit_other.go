@@ -0,0 +1,32 @@
+//go:build !linux
+
+/*
+Copyright 2024 The KCL authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kcl
+
+import (
+	"runtime/debug"
+	"time"
+)
+
+// setResourceLimits only applies a soft memory limit outside of Linux.
+func setResourceLimits(maxMemory int64, _ time.Duration) error {
+	if maxMemory > 0 {
+		debug.SetMemoryLimit(maxMemory)
+	}
+	return nil
+}
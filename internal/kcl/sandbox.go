@@ -0,0 +1,253 @@
+/*
+Copyright 2024 The KCL authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kcl
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kcl-lang/flux-kcl-controller/api/v1alpha1"
+)
+
+// SandboxCommand is the controller subcommand compiling a KCL package
+// in a sandboxed subprocess.
+const SandboxCommand = "kcl-compile"
+
+// sandboxResolutionExitCode is the exit code of the sandbox when the
+// compilation failed to resolve the dependencies.
+const sandboxResolutionExitCode = 3
+
+// ErrCompileTimeout is returned when the compilation does not finish
+// before the context deadline.
+var ErrCompileTimeout = errors.New("KCL compilation timed out")
+
+// ErrCompileInProgress is returned when an in-process compilation of the
+// KCLRun which timed out is still running.
+var ErrCompileInProgress = errors.New("a previous KCL compilation of the KCLRun is still running")
+
+// inProcessCompilations tracks the KCLRuns with a running in-process
+// compilation, which cannot be interrupted.
+var inProcessCompilations = &compilations{running: map[string]bool{}}
+
+type compilations struct {
+	mu      sync.Mutex
+	running map[string]bool
+}
+
+// start marks the compilation of the key as running, returning false if
+// it is already running.
+func (c *compilations) start(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.running[key] {
+		return false
+	}
+	c.running[key] = true
+	return true
+}
+
+// done marks the compilation of the key as finished.
+func (c *compilations) done(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.running, key)
+}
+
+// CompileOptions configures how KCL packages are compiled.
+type CompileOptions struct {
+	// Sandbox runs the compilation in a subprocess of the controller binary,
+	// so that it can be killed on timeout and bounded in resources. Otherwise
+	// a compilation which timed out keeps running in the background, and the
+	// next compilations of the same KCLRun are refused until it finishes.
+	Sandbox bool
+
+	// MaxMemory is the memory limit in bytes of the sandbox,
+	// it is unlimited when zero.
+	MaxMemory int64
+
+	// MaxCPUTime is the CPU time limit of the sandbox,
+	// it is unlimited when zero.
+	MaxCPUTime time.Duration
+
+	// Modules configures how the KCL module dependencies are resolved.
+	Modules ModuleOptions
+}
+
+// sandboxRequest is the compilation request sent to the sandbox on stdin.
+type sandboxRequest struct {
+	Object     *v1alpha1.KCLRun `json:"object"`
+	PkgPath    string           `json:"pkgPath"`
+	Arguments  []sandboxArg     `json:"arguments"`
+	Modules    ModuleOptions    `json:"modules"`
+	MaxMemory  int64            `json:"maxMemory"`
+	MaxCPUTime time.Duration    `json:"maxCPUTime"`
+}
+
+// sandboxArg is an argument with its value already encoded for KCL.
+type sandboxArg struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Compile compiles the KCL package at pkgPath into Kubernetes manifests,
+// returning ErrCompileTimeout if the context is done before it finishes
+// and ErrLockMismatch if the dependencies do not match the kcl.mod.lock.
+// The sources of the dependencies are rewritten by the module rewrites.
+func Compile(ctx context.Context, obj *v1alpha1.KCLRun, pkgPath string, arguments []Argument, opts CompileOptions) ([]byte, error) {
+	if err := opts.Modules.rewriteDependencies(pkgPath); err != nil {
+		return nil, err
+	}
+	lock, err := newLockCheck(obj, pkgPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifests []byte
+	if opts.Sandbox {
+		manifests, err = compileInSubprocess(ctx, obj, pkgPath, arguments, opts)
+	} else {
+		manifests, err = compileInProcess(ctx, obj, pkgPath, arguments, opts)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := lock.verify(); err != nil {
+		return nil, err
+	}
+	return manifests, nil
+}
+
+func compileInProcess(ctx context.Context, obj *v1alpha1.KCLRun, pkgPath string, arguments []Argument, opts CompileOptions) ([]byte, error) {
+	key := obj.GetNamespace() + "/" + obj.GetName()
+	if !inProcessCompilations.start(key) {
+		return nil, ErrCompileInProgress
+	}
+
+	type result struct {
+		manifests []byte
+		err       error
+	}
+	// The compilation cannot be interrupted in-process, it is left to finish
+	// in the background when the context is done.
+	done := make(chan result, 1)
+	go func() {
+		defer inProcessCompilations.done(key)
+		res, err := CompileKclPackage(obj, pkgPath, arguments, opts.Modules, os.Stderr)
+		if err != nil {
+			done <- result{err: err}
+			return
+		}
+		done <- result{manifests: []byte(res.GetRawYamlResult())}
+	}()
+
+	select {
+	case r := <-done:
+		return r.manifests, r.err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("%w: %w", ErrCompileTimeout, ctx.Err())
+	}
+}
+
+func compileInSubprocess(ctx context.Context, obj *v1alpha1.KCLRun, pkgPath string, arguments []Argument, opts CompileOptions) ([]byte, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate the controller executable: %w", err)
+	}
+
+	req := sandboxRequest{
+		Object:     obj,
+		PkgPath:    pkgPath,
+		Modules:    opts.Modules,
+		MaxMemory:  opts.MaxMemory,
+		MaxCPUTime: opts.MaxCPUTime,
+	}
+	for _, argument := range arguments {
+		arg, err := argument.Encode()
+		if err != nil {
+			return nil, err
+		}
+		_, value, _ := strings.Cut(arg, "=")
+		req.Arguments = append(req.Arguments, sandboxArg{Name: argument.Name, Value: value})
+	}
+	input, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, executable, SandboxCommand)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("%w: %w", ErrCompileTimeout, ctx.Err())
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			if cmd.ProcessState.ExitCode() == sandboxResolutionExitCode {
+				return nil, &resolutionError{msg: msg}
+			}
+			return nil, errors.New(msg)
+		}
+		return nil, fmt.Errorf("KCL compilation failed: %w", err)
+	}
+	return stdout.Bytes(), nil
+}
+
+// RunSandbox serves a compilation request read from stdin, writing the
+// manifests to stdout and the kpm messages and the compile error to stderr.
+// It returns the exit code of the sandbox process.
+func RunSandbox(stdin io.Reader, stdout, stderr io.Writer) int {
+	var req sandboxRequest
+	if err := json.NewDecoder(stdin).Decode(&req); err != nil {
+		fmt.Fprintf(stderr, "invalid compilation request: %s\n", err)
+		return 2
+	}
+	if err := setResourceLimits(req.MaxMemory, req.MaxCPUTime); err != nil {
+		fmt.Fprintf(stderr, "failed to set resource limits: %s\n", err)
+		return 2
+	}
+
+	arguments := make([]Argument, 0, len(req.Arguments))
+	for _, arg := range req.Arguments {
+		arguments = append(arguments, Argument{Name: arg.Name, Value: Literal(arg.Value)})
+	}
+	// The kpm messages are sent to stderr, they would otherwise be mixed
+	// with the manifests on stdout.
+	res, err := CompileKclPackage(req.Object, req.PkgPath, arguments, req.Modules, stderr)
+	if err != nil {
+		fmt.Fprintln(stderr, err.Error())
+		if IsDependencyResolutionError(err) {
+			return sandboxResolutionExitCode
+		}
+		return 1
+	}
+	if _, err := io.WriteString(stdout, res.GetRawYamlResult()); err != nil {
+		fmt.Fprintln(stderr, err.Error())
+		return 1
+	}
+	return 0
+}
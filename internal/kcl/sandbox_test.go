@@ -0,0 +1,108 @@
+/*
+Copyright 2024 The KCL authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kcl
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/yaml"
+
+	"github.com/kcl-lang/flux-kcl-controller/api/v1alpha1"
+)
+
+// TestMain serves the sandbox subcommand when the test binary is re-run
+// by the sandboxed compilations, as the controller binary is in production.
+func TestMain(m *testing.M) {
+	if len(os.Args) > 1 && os.Args[1] == SandboxCommand {
+		os.Exit(RunSandbox(os.Stdin, os.Stdout, os.Stderr))
+	}
+	os.Exit(m.Run())
+}
+
+// compileInSandbox compiles a copy of the testdata package in a sandboxed
+// subprocess of the test binary.
+func compileInSandbox(t *testing.T, pkg string, opts CompileOptions) ([]byte, error) {
+	t.Helper()
+	pkgPath := t.TempDir()
+	require.NoError(t, os.CopyFS(pkgPath, os.DirFS(filepath.Join("testdata", "sandbox", pkg))))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	opts.Sandbox = true
+	obj := &v1alpha1.KCLRun{}
+	obj.Name, obj.Namespace = pkg, "default"
+	return Compile(ctx, obj, pkgPath, nil, opts)
+}
+
+func TestSandboxCompile(t *testing.T) {
+	manifests, err := compileInSandbox(t, "success", CompileOptions{})
+	require.NoError(t, err)
+
+	var configMap map[string]any
+	require.NoError(t, yaml.Unmarshal(manifests, &configMap))
+	assert.Equal(t, map[string]any{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata":   map[string]any{"name": "app"},
+		"data":       map[string]any{"key": "value"},
+	}, configMap)
+}
+
+func TestSandboxCompileError(t *testing.T) {
+	_, err := compileInSandbox(t, "error", CompileOptions{})
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, ErrCompileTimeout)
+	assert.Contains(t, err.Error(), "main.k")
+}
+
+func TestSandboxResourceLimits(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the sandbox resource limits are only enforced on Linux")
+	}
+	_, err := compileInSandbox(t, "exhaust", CompileOptions{
+		MaxMemory:  512 << 20,
+		MaxCPUTime: 2 * time.Second,
+	})
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, ErrCompileTimeout)
+}
+
+func TestRunSandboxInvalidRequest(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	code := RunSandbox(strings.NewReader("{"), &stdout, &stderr)
+	assert.Equal(t, 2, code)
+	assert.Empty(t, stdout.String())
+	assert.Contains(t, stderr.String(), "invalid compilation request")
+}
+
+func TestCompilations(t *testing.T) {
+	c := &compilations{running: map[string]bool{}}
+	assert.True(t, c.start("apps/a"))
+	assert.False(t, c.start("apps/a"))
+	assert.True(t, c.start("apps/b"))
+	c.done("apps/a")
+	assert.True(t, c.start("apps/a"))
+}
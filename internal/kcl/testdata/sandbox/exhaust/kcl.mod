@@ -0,0 +1,4 @@
+[package]
+name = "exhaust"
+edition = "v0.11.1"
+version = "0.0.1"
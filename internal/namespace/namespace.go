@@ -0,0 +1,80 @@
+/*
+Copyright 2024 The KCL authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package namespace
+
+import (
+	"fmt"
+
+	ssautil "github.com/fluxcd/pkg/ssa/utils"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// SetTarget sets the given namespace on the namespaced objects that do not
+// specify one. The scope of a kind is looked up in the CRDs contained in the
+// objects first, and with the REST mapper otherwise. Objects of an unknown
+// kind are left untouched. If strict is true, an error is returned for the
+// namespaced objects that target a different namespace.
+func SetTarget(mapper apimeta.RESTMapper, objects []*unstructured.Unstructured, namespace string, strict bool) error {
+	scopes := crdScopes(objects)
+	for _, u := range objects {
+		gvk := u.GroupVersionKind()
+		namespaced, ok := scopes[gvk.GroupKind()]
+		if !ok {
+			mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+			if err != nil {
+				if apimeta.IsNoMatchError(err) {
+					continue
+				}
+				return fmt.Errorf("failed to get the scope of %s: %w", ssautil.FmtUnstructured(u), err)
+			}
+			namespaced = mapping.Scope.Name() == apimeta.RESTScopeNameNamespace
+		}
+		if !namespaced {
+			continue
+		}
+
+		switch u.GetNamespace() {
+		case "":
+			u.SetNamespace(namespace)
+		case namespace:
+		default:
+			if strict {
+				return fmt.Errorf("%s targets namespace '%s' instead of '%s'",
+					ssautil.FmtUnstructured(u), u.GetNamespace(), namespace)
+			}
+		}
+	}
+	return nil
+}
+
+// crdScopes returns whether the kinds defined by the CRDs contained in the
+// given objects are namespaced.
+func crdScopes(objects []*unstructured.Unstructured) map[schema.GroupKind]bool {
+	scopes := map[schema.GroupKind]bool{}
+	for _, u := range objects {
+		if !ssautil.IsCRD(u) {
+			continue
+		}
+		group, _, _ := unstructured.NestedString(u.Object, "spec", "group")
+		kind, _, _ := unstructured.NestedString(u.Object, "spec", "names", "kind")
+		scope, _, _ := unstructured.NestedString(u.Object, "spec", "scope")
+		scopes[schema.GroupKind{Group: group, Kind: kind}] = scope == "Namespaced"
+	}
+	return scopes
+}
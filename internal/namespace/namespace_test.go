@@ -0,0 +1,66 @@
+/*
+Copyright 2024 The KCL authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package namespace
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func newObject(apiVersion, kind, name, namespace string) *unstructured.Unstructured {
+	u := &unstructured.Unstructured{}
+	u.SetAPIVersion(apiVersion)
+	u.SetKind(kind)
+	u.SetName(name)
+	u.SetNamespace(namespace)
+	return u
+}
+
+func newMapper() apimeta.RESTMapper {
+	mapper := apimeta.NewDefaultRESTMapper(nil)
+	mapper.Add(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}, apimeta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "Namespace"}, apimeta.RESTScopeRoot)
+	return mapper
+}
+
+func TestSetTarget(t *testing.T) {
+	crd := newObject("apiextensions.k8s.io/v1", "CustomResourceDefinition", "widgets.example.com", "")
+	_ = unstructured.SetNestedField(crd.Object, "example.com", "spec", "group")
+	_ = unstructured.SetNestedField(crd.Object, "Widget", "spec", "names", "kind")
+	_ = unstructured.SetNestedField(crd.Object, "Namespaced", "spec", "scope")
+
+	deployment := newObject("apps/v1", "Deployment", "app", "")
+	ns := newObject("v1", "Namespace", "apps", "")
+	widget := newObject("example.com/v1", "Widget", "widget", "")
+	unknown := newObject("unknown.io/v1", "Thing", "thing", "")
+	other := newObject("apps/v1", "Deployment", "other", "other")
+
+	objects := []*unstructured.Unstructured{crd, deployment, ns, widget, unknown, other}
+	assert.NoError(t, SetTarget(newMapper(), objects, "apps", false))
+	assert.Equal(t, "apps", deployment.GetNamespace())
+	assert.Equal(t, "", ns.GetNamespace())
+	assert.Equal(t, "apps", widget.GetNamespace())
+	assert.Equal(t, "", unknown.GetNamespace())
+	assert.Equal(t, "other", other.GetNamespace())
+
+	err := SetTarget(newMapper(), objects, "apps", true)
+	assert.ErrorContains(t, err, "targets namespace 'other' instead of 'apps'")
+}
@@ -0,0 +1,192 @@
+/*
+Copyright 2024 The KCL authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package oci
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/content/memory"
+	"oras.land/oras-go/v2/registry/remote"
+	"oras.land/oras-go/v2/registry/remote/auth"
+	"oras.land/oras-go/v2/registry/remote/credentials"
+	"oras.land/oras-go/v2/registry/remote/retry"
+)
+
+const (
+	// ConfigMediaType is the media type of the config of the Flux OCI artifacts.
+	ConfigMediaType = "application/vnd.cncf.flux.config.v1+json"
+	// ContentMediaType is the media type of the content layer of the Flux OCI artifacts.
+	ContentMediaType = "application/vnd.cncf.flux.content.v1.tar+gzip"
+	// ManifestsFile is the name of the file holding the manifests in the artifact content.
+	ManifestsFile = "manifests.yaml"
+)
+
+// maxTagLength is the maximum length of an OCI tag.
+const maxTagLength = 128
+
+// invalidTagChars matches the characters which are not allowed in OCI tags.
+var invalidTagChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// PushOptions configures how the manifests are pushed.
+type PushOptions struct {
+	// PlainHTTP pushes to the registry over plain HTTP.
+	PlainHTTP bool
+
+	// CredentialsFile is the docker config.json file holding the registry
+	// credentials, the push is anonymous when empty.
+	CredentialsFile string
+
+	// Source is the URL of the source the manifests were rendered from,
+	// recorded in the 'org.opencontainers.image.source' annotation.
+	Source string
+
+	// Revision is the revision the manifests were rendered from, recorded
+	// in the 'org.opencontainers.image.revision' annotation.
+	Revision string
+}
+
+// NewRepository returns the client of the OCI repository at the 'oci://' url,
+// authenticating with the docker config at credentialsFile if not empty.
+func NewRepository(url string, plainHTTP bool, credentialsFile string) (*remote.Repository, error) {
+	reference, ok := strings.CutPrefix(url, "oci://")
+	if !ok {
+		return nil, fmt.Errorf("invalid OCI repository URL '%s': must start with 'oci://'", url)
+	}
+	repo, err := remote.NewRepository(strings.TrimSuffix(reference, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid OCI repository URL '%s': %w", url, err)
+	}
+	repo.PlainHTTP = plainHTTP
+
+	client := &auth.Client{
+		Client: retry.DefaultClient,
+		Cache:  auth.NewCache(),
+	}
+	if credentialsFile != "" {
+		store, err := credentials.NewStore(credentialsFile, credentials.StoreOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the registry credentials: %w", err)
+		}
+		client.Credential = credentials.Credential(store)
+	}
+	repo.Client = client
+	return repo, nil
+}
+
+// Push pushes the manifests as a Flux OCI artifact, which can be consumed by
+// an OCIRepository, to the repository at the 'oci://' url with the given tag.
+// It returns the digest of the pushed OCI manifest.
+func Push(ctx context.Context, url, tag string, manifests []byte, opts PushOptions) (string, error) {
+	repo, err := NewRepository(url, opts.PlainHTTP, opts.CredentialsFile)
+	if err != nil {
+		return "", err
+	}
+
+	layer, err := archive(manifests)
+	if err != nil {
+		return "", fmt.Errorf("failed to archive the manifests: %w", err)
+	}
+	config := []byte("{}")
+
+	store := memory.New()
+	configDesc := content.NewDescriptorFromBytes(ConfigMediaType, config)
+	layerDesc := content.NewDescriptorFromBytes(ContentMediaType, layer)
+	for _, blob := range []struct {
+		desc ocispec.Descriptor
+		data []byte
+	}{{configDesc, config}, {layerDesc, layer}} {
+		if err := store.Push(ctx, blob.desc, bytes.NewReader(blob.data)); err != nil {
+			return "", err
+		}
+	}
+
+	annotations := map[string]string{}
+	if opts.Source != "" {
+		annotations[ocispec.AnnotationSource] = opts.Source
+	}
+	if opts.Revision != "" {
+		annotations[ocispec.AnnotationRevision] = opts.Revision
+	}
+	manifestDesc, err := oras.PackManifest(ctx, store, oras.PackManifestVersion1_0, "", oras.PackManifestOptions{
+		ConfigDescriptor:    &configDesc,
+		Layers:              []ocispec.Descriptor{layerDesc},
+		ManifestAnnotations: annotations,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to pack the OCI manifest: %w", err)
+	}
+	if err := store.Tag(ctx, manifestDesc, tag); err != nil {
+		return "", err
+	}
+
+	if _, err := oras.Copy(ctx, store, tag, repo, tag, oras.DefaultCopyOptions); err != nil {
+		return "", fmt.Errorf("failed to push to '%s:%s': %w", url, tag, err)
+	}
+	return manifestDesc.Digest.String(), nil
+}
+
+// TagFromRevision returns a valid OCI tag for the source revision,
+// e.g. 'main@sha1:1a2b3c' becomes 'main-sha1-1a2b3c'.
+func TagFromRevision(revision string) string {
+	tag := invalidTagChars.ReplaceAllString(revision, "-")
+	tag = strings.TrimLeft(tag, ".-")
+	if len(tag) > maxTagLength {
+		tag = tag[:maxTagLength]
+	}
+	if tag == "" {
+		return "latest"
+	}
+	return tag
+}
+
+// archive returns a gzip compressed tarball holding the manifests file,
+// with a fixed modification time so that the same manifests produce the
+// same digest.
+func archive(manifests []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	if err := tw.WriteHeader(&tar.Header{
+		Name:     ManifestsFile,
+		Mode:     0o644,
+		Size:     int64(len(manifests)),
+		ModTime:  time.Unix(0, 0),
+		Typeflag: tar.TypeReg,
+	}); err != nil {
+		return nil, err
+	}
+	if _, err := tw.Write(manifests); err != nil {
+		return nil, err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,168 @@
+/*
+Copyright 2024 The KCL authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package oci
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// registry is a minimal in-memory OCI distribution registry.
+type registry struct {
+	mu        sync.Mutex
+	blobs     map[string][]byte
+	manifests map[string][]byte
+	uploads   int
+}
+
+func newRegistry(t *testing.T) (*registry, string) {
+	r := &registry{blobs: map[string][]byte{}, manifests: map[string][]byte{}}
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+	return r, strings.TrimPrefix(server.URL, "http://")
+}
+
+func (r *registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	path := strings.TrimPrefix(req.URL.Path, "/v2/")
+	switch {
+	case strings.Contains(path, "/blobs/uploads/"):
+		name, id, _ := strings.Cut(path, "/blobs/uploads/")
+		switch req.Method {
+		case http.MethodPost:
+			r.uploads++
+			w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/%d", name, r.uploads))
+			w.WriteHeader(http.StatusAccepted)
+		case http.MethodPut:
+			data, _ := io.ReadAll(req.Body)
+			d := req.URL.Query().Get("digest")
+			if id == "" || digest.FromBytes(data).String() != d {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			r.blobs[d] = data
+			w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/%s", name, d))
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	case strings.Contains(path, "/blobs/"):
+		_, d, _ := strings.Cut(path, "/blobs/")
+		r.serve(w, req, r.blobs[d], "application/octet-stream")
+	case strings.Contains(path, "/manifests/"):
+		_, ref, _ := strings.Cut(path, "/manifests/")
+		if req.Method == http.MethodPut {
+			data, _ := io.ReadAll(req.Body)
+			d := digest.FromBytes(data).String()
+			r.manifests[ref] = data
+			r.manifests[d] = data
+			w.Header().Set("Docker-Content-Digest", d)
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		r.serve(w, req, r.manifests[ref], ocispec.MediaTypeImageManifest)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (r *registry) serve(w http.ResponseWriter, req *http.Request, data []byte, mediaType string) {
+	if data == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", mediaType)
+	w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+	w.Header().Set("Docker-Content-Digest", digest.FromBytes(data).String())
+	if req.Method == http.MethodGet {
+		_, _ = w.Write(data)
+	}
+}
+
+func TestPush(t *testing.T) {
+	reg, host := newRegistry(t)
+	manifests := []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: test\n")
+
+	d, err := Push(context.Background(), "oci://"+host+"/kcl/output", "main-sha1-1a2b3c", manifests, PushOptions{
+		PlainHTTP: true,
+		Source:    "oci://ghcr.io/kcl-lang/app",
+		Revision:  "main@sha1:1a2b3c",
+	})
+	require.NoError(t, err)
+
+	data, ok := reg.manifests["main-sha1-1a2b3c"]
+	require.True(t, ok)
+	assert.Equal(t, digest.FromBytes(data).String(), d)
+
+	var manifest ocispec.Manifest
+	require.NoError(t, json.Unmarshal(data, &manifest))
+	assert.Equal(t, ConfigMediaType, manifest.Config.MediaType)
+	assert.Equal(t, "main@sha1:1a2b3c", manifest.Annotations[ocispec.AnnotationRevision])
+	assert.Equal(t, "oci://ghcr.io/kcl-lang/app", manifest.Annotations[ocispec.AnnotationSource])
+	require.Len(t, manifest.Layers, 1)
+	assert.Equal(t, ContentMediaType, manifest.Layers[0].MediaType)
+
+	gr, err := gzip.NewReader(bytes.NewReader(reg.blobs[manifest.Layers[0].Digest.String()]))
+	require.NoError(t, err)
+	tr := tar.NewReader(gr)
+	header, err := tr.Next()
+	require.NoError(t, err)
+	assert.Equal(t, ManifestsFile, header.Name)
+	content, err := io.ReadAll(tr)
+	require.NoError(t, err)
+	assert.Equal(t, manifests, content)
+
+	// The same manifests are pushed with the same digest.
+	again, err := Push(context.Background(), "oci://"+host+"/kcl/output", "main-sha1-1a2b3c", manifests, PushOptions{
+		PlainHTTP: true,
+		Source:    "oci://ghcr.io/kcl-lang/app",
+		Revision:  "main@sha1:1a2b3c",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, d, again)
+}
+
+func TestPushInvalidURL(t *testing.T) {
+	_, err := Push(context.Background(), "ghcr.io/kcl/output", "latest", nil, PushOptions{})
+	assert.ErrorContains(t, err, "must start with 'oci://'")
+}
+
+func TestTagFromRevision(t *testing.T) {
+	assert.Equal(t, "main-sha1-1a2b3c", TagFromRevision("main@sha1:1a2b3c"))
+	assert.Equal(t, "v1.0.0-sha256-abc", TagFromRevision("v1.0.0@sha256:abc"))
+	assert.Equal(t, "sha256-abc", TagFromRevision("sha256:abc"))
+	assert.Equal(t, "feature-x-sha1-1a2b3c", TagFromRevision("feature/x@sha1:1a2b3c"))
+	assert.Equal(t, "latest", TagFromRevision(""))
+	assert.Len(t, TagFromRevision(strings.Repeat("a", 200)), maxTagLength)
+}
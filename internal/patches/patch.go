@@ -0,0 +1,210 @@
+/*
+Copyright 2024 The KCL authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package patches
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	ssautil "github.com/fluxcd/pkg/ssa/utils"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/yaml"
+
+	"github.com/kcl-lang/flux-kcl-controller/api/v1alpha1"
+)
+
+// Apply applies the patches in order to the objects matching their target.
+// A patch whose content is a list of operations is applied as a JSON6902
+// patch, any other patch is applied as a strategic merge patch. Objects of
+// a kind unknown to the Kubernetes scheme are patched with a JSON merge patch
+// instead of a strategic merge patch.
+func Apply(objects []*unstructured.Unstructured, patches []v1alpha1.Patch) error {
+	for i, p := range patches {
+		data, err := yaml.YAMLToJSON([]byte(p.Patch))
+		if err != nil {
+			return fmt.Errorf("patch %d: failed to decode: %w", i, err)
+		}
+
+		var apply func(u *unstructured.Unstructured) error
+		target := p.Target
+		if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+			if target == nil {
+				return fmt.Errorf("patch %d: a JSON6902 patch requires a target", i)
+			}
+			ops, err := jsonpatch.DecodePatch(data)
+			if err != nil {
+				return fmt.Errorf("patch %d: failed to decode the JSON6902 patch: %w", i, err)
+			}
+			apply = func(u *unstructured.Unstructured) error {
+				return patchObject(u, func(doc []byte) ([]byte, error) {
+					return ops.Apply(doc)
+				})
+			}
+		} else {
+			if target == nil {
+				target, err = patchTarget(data)
+				if err != nil {
+					return fmt.Errorf("patch %d: %w", i, err)
+				}
+			}
+			content, err := withoutIdentity(data)
+			if err != nil {
+				return fmt.Errorf("patch %d: failed to decode the strategic merge patch: %w", i, err)
+			}
+			apply = func(u *unstructured.Unstructured) error {
+				return patchObject(u, func(doc []byte) ([]byte, error) {
+					return strategicMergePatch(u.GroupVersionKind(), doc, content)
+				})
+			}
+		}
+
+		matches, err := selectorMatcher(target)
+		if err != nil {
+			return fmt.Errorf("patch %d: invalid target: %w", i, err)
+		}
+		for _, u := range objects {
+			if !matches(u) {
+				continue
+			}
+			if err := apply(u); err != nil {
+				return fmt.Errorf("patch %d: failed to patch %s: %w", i, ssautil.FmtUnstructured(u), err)
+			}
+		}
+	}
+	return nil
+}
+
+// patchObject replaces the content of the object with the result of the
+// patch function applied to its JSON encoding.
+func patchObject(u *unstructured.Unstructured, patch func(doc []byte) ([]byte, error)) error {
+	doc, err := u.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	patched, err := patch(doc)
+	if err != nil {
+		return err
+	}
+	result := &unstructured.Unstructured{}
+	if err := result.UnmarshalJSON(patched); err != nil {
+		return err
+	}
+	u.Object = result.Object
+	return nil
+}
+
+// strategicMergePatch applies the strategic merge patch to the document of
+// the given kind, falling back to a JSON merge patch for the kinds unknown
+// to the Kubernetes scheme.
+func strategicMergePatch(gvk schema.GroupVersionKind, doc, patch []byte) ([]byte, error) {
+	dataStruct, err := scheme.Scheme.New(gvk)
+	if err != nil {
+		return jsonpatch.MergePatch(doc, patch)
+	}
+	return strategicpatch.StrategicMergePatch(doc, patch, dataStruct)
+}
+
+// withoutIdentity returns the strategic merge patch without its apiVersion,
+// kind, name and namespace, so that the patch never renames the objects
+// selected by a target.
+func withoutIdentity(patch []byte) ([]byte, error) {
+	var content map[string]interface{}
+	if err := json.Unmarshal(patch, &content); err != nil {
+		return nil, err
+	}
+	delete(content, "apiVersion")
+	delete(content, "kind")
+	unstructured.RemoveNestedField(content, "metadata", "name")
+	unstructured.RemoveNestedField(content, "metadata", "namespace")
+	return json.Marshal(content)
+}
+
+// patchTarget returns the selector of the object identified by the
+// apiVersion, kind, name and namespace of a strategic merge patch.
+func patchTarget(patch []byte) (*v1alpha1.Selector, error) {
+	u := &unstructured.Unstructured{}
+	if err := json.Unmarshal(patch, &u.Object); err != nil {
+		return nil, fmt.Errorf("failed to decode the strategic merge patch: %w", err)
+	}
+	if u.GetName() == "" {
+		return nil, fmt.Errorf("a strategic merge patch without target must specify metadata.name")
+	}
+	gvk := u.GroupVersionKind()
+	target := &v1alpha1.Selector{
+		Group:   gvk.Group,
+		Version: gvk.Version,
+		Kind:    gvk.Kind,
+		Name:    regexp.QuoteMeta(u.GetName()),
+	}
+	if u.GetNamespace() != "" {
+		target.Namespace = regexp.QuoteMeta(u.GetNamespace())
+	}
+	return target, nil
+}
+
+// selectorMatcher returns a function reporting whether an object matches
+// all the fields set in the selector. The name and the namespace are
+// matched as anchored regular expressions, like Kustomize does.
+func selectorMatcher(selector *v1alpha1.Selector) (func(u *unstructured.Unstructured) bool, error) {
+	name, err := anchoredRegexp(selector.Name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid name: %w", err)
+	}
+	namespace, err := anchoredRegexp(selector.Namespace)
+	if err != nil {
+		return nil, fmt.Errorf("invalid namespace: %w", err)
+	}
+	labelSelector, err := labels.Parse(selector.LabelSelector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid label selector: %w", err)
+	}
+	annotationSelector, err := labels.Parse(selector.AnnotationSelector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid annotation selector: %w", err)
+	}
+
+	return func(u *unstructured.Unstructured) bool {
+		gvk := u.GroupVersionKind()
+		switch {
+		case selector.Group != "" && selector.Group != gvk.Group,
+			selector.Version != "" && selector.Version != gvk.Version,
+			selector.Kind != "" && selector.Kind != gvk.Kind,
+			name != nil && !name.MatchString(u.GetName()),
+			namespace != nil && !namespace.MatchString(u.GetNamespace()),
+			!labelSelector.Matches(labels.Set(u.GetLabels())),
+			!annotationSelector.Matches(labels.Set(u.GetAnnotations())):
+			return false
+		}
+		return true
+	}, nil
+}
+
+// anchoredRegexp compiles the expression matching whole strings,
+// or returns nil for an empty expression.
+func anchoredRegexp(expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	return regexp.Compile("^(?:" + expr + ")$")
+}
@@ -0,0 +1,209 @@
+/*
+Copyright 2024 The KCL authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package patches
+
+import (
+	"strings"
+	"testing"
+
+	ssautil "github.com/fluxcd/pkg/ssa/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/kcl-lang/flux-kcl-controller/api/v1alpha1"
+)
+
+const manifests = `---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: frontend
+  namespace: apps
+  labels:
+    tier: web
+spec:
+  replicas: 1
+  template:
+    spec:
+      containers:
+      - name: app
+        image: nginx:1.25
+      - name: sidecar
+        image: envoy:1.30
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: backend
+  namespace: apps
+spec:
+  replicas: 1
+---
+apiVersion: example.com/v1
+kind: Widget
+metadata:
+  name: frontend
+  namespace: apps
+spec:
+  size: small
+  colors:
+  - red
+`
+
+func readObjects(t *testing.T) []*unstructured.Unstructured {
+	objects, err := ssautil.ReadObjects(strings.NewReader(manifests))
+	require.NoError(t, err)
+	return objects
+}
+
+func TestApplyStrategicMerge(t *testing.T) {
+	objects := readObjects(t)
+	err := Apply(objects, []v1alpha1.Patch{{
+		Patch: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: frontend
+spec:
+  replicas: 3
+  template:
+    spec:
+      containers:
+      - name: app
+        image: nginx:1.27
+`,
+	}})
+	require.NoError(t, err)
+
+	replicas, _, _ := unstructured.NestedInt64(objects[0].Object, "spec", "replicas")
+	assert.Equal(t, int64(3), replicas)
+	containers, _, _ := unstructured.NestedSlice(objects[0].Object, "spec", "template", "spec", "containers")
+	require.Len(t, containers, 2)
+	assert.Equal(t, "nginx:1.27", containers[0].(map[string]interface{})["image"])
+	assert.Equal(t, "envoy:1.30", containers[1].(map[string]interface{})["image"])
+
+	replicas, _, _ = unstructured.NestedInt64(objects[1].Object, "spec", "replicas")
+	assert.Equal(t, int64(1), replicas)
+	_, found, _ := unstructured.NestedInt64(objects[2].Object, "spec", "replicas")
+	assert.False(t, found)
+}
+
+func TestApplyStrategicMergeTarget(t *testing.T) {
+	objects := readObjects(t)
+	err := Apply(objects, []v1alpha1.Patch{{
+		Patch: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: not-used
+  annotations:
+    patched: "true"
+`,
+		Target: &v1alpha1.Selector{Kind: "Deployment", Name: ".*end"},
+	}})
+	require.NoError(t, err)
+
+	for _, u := range objects[:2] {
+		assert.Equal(t, "true", u.GetAnnotations()["patched"])
+	}
+	assert.Equal(t, "frontend", objects[0].GetName())
+	assert.Equal(t, "backend", objects[1].GetName())
+	assert.Empty(t, objects[2].GetAnnotations())
+}
+
+func TestApplyMergeCustomResource(t *testing.T) {
+	objects := readObjects(t)
+	err := Apply(objects, []v1alpha1.Patch{{
+		Patch:  `{"spec": {"size": "large", "colors": ["blue"]}}`,
+		Target: &v1alpha1.Selector{Group: "example.com", Kind: "Widget"},
+	}})
+	require.NoError(t, err)
+
+	size, _, _ := unstructured.NestedString(objects[2].Object, "spec", "size")
+	assert.Equal(t, "large", size)
+	colors, _, _ := unstructured.NestedStringSlice(objects[2].Object, "spec", "colors")
+	assert.Equal(t, []string{"blue"}, colors)
+}
+
+func TestApplyJSON6902(t *testing.T) {
+	objects := readObjects(t)
+	err := Apply(objects, []v1alpha1.Patch{{
+		Patch: `
+- op: replace
+  path: /spec/replicas
+  value: 5
+- op: add
+  path: /metadata/labels/patched
+  value: "true"
+`,
+		Target: &v1alpha1.Selector{Group: "apps", Version: "v1", Kind: "Deployment", LabelSelector: "tier=web"},
+	}})
+	require.NoError(t, err)
+
+	replicas, _, _ := unstructured.NestedInt64(objects[0].Object, "spec", "replicas")
+	assert.Equal(t, int64(5), replicas)
+	assert.Equal(t, "true", objects[0].GetLabels()["patched"])
+	replicas, _, _ = unstructured.NestedInt64(objects[1].Object, "spec", "replicas")
+	assert.Equal(t, int64(1), replicas)
+}
+
+func TestApplyErrors(t *testing.T) {
+	err := Apply(readObjects(t), []v1alpha1.Patch{{
+		Patch: `[{"op": "remove", "path": "/spec/replicas"}]`,
+	}})
+	assert.ErrorContains(t, err, "requires a target")
+
+	err = Apply(readObjects(t), []v1alpha1.Patch{{
+		Patch:  `[{"op": "remove", "path": "/spec/missing"}]`,
+		Target: &v1alpha1.Selector{Name: "backend"},
+	}})
+	assert.ErrorContains(t, err, "failed to patch Deployment/apps/backend")
+
+	err = Apply(readObjects(t), []v1alpha1.Patch{{
+		Patch: `{"spec": {"replicas": 2}}`,
+	}})
+	assert.ErrorContains(t, err, "must specify metadata.name")
+
+	err = Apply(readObjects(t), []v1alpha1.Patch{{
+		Patch:  `{"spec": {"replicas": 2}}`,
+		Target: &v1alpha1.Selector{LabelSelector: "tier in (web"},
+	}})
+	assert.ErrorContains(t, err, "invalid label selector")
+}
+
+func TestSelectorMatcher(t *testing.T) {
+	objects := readObjects(t)
+	tests := []struct {
+		selector v1alpha1.Selector
+		want     []bool
+	}{
+		{v1alpha1.Selector{}, []bool{true, true, true}},
+		{v1alpha1.Selector{Name: "frontend"}, []bool{true, false, true}},
+		{v1alpha1.Selector{Name: "front"}, []bool{false, false, false}},
+		{v1alpha1.Selector{Namespace: "app.*", Group: "apps"}, []bool{true, true, false}},
+		{v1alpha1.Selector{Version: "v1", Kind: "Widget"}, []bool{false, false, true}},
+		{v1alpha1.Selector{LabelSelector: "!tier"}, []bool{false, true, true}},
+	}
+	for _, tt := range tests {
+		matches, err := selectorMatcher(&tt.selector)
+		require.NoError(t, err)
+		for i, u := range objects {
+			assert.Equal(t, tt.want[i], matches(u), "%+v %s", tt.selector, ssautil.FmtUnstructured(u))
+		}
+	}
+}
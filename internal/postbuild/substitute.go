@@ -0,0 +1,153 @@
+/*
+Copyright 2024 The KCL authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package postbuild
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	ssautil "github.com/fluxcd/pkg/ssa/utils"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+
+	"github.com/kcl-lang/flux-kcl-controller/api/v1alpha1"
+)
+
+// varNameRegexp matches the valid variable names.
+var varNameRegexp = regexp.MustCompile(`^[_[:alpha:]][_[:alpha:][:digit:]]*$`)
+
+// ValidateVariables returns an error if a variable name is invalid.
+func ValidateVariables(vars map[string]string) error {
+	for name := range vars {
+		if !varNameRegexp.MatchString(name) {
+			return fmt.Errorf("'%s' var name is invalid, must match '%s'", name, varNameRegexp.String())
+		}
+	}
+	return nil
+}
+
+// Substitute replaces the ${var} expressions of the objects with the value
+// of the variables. Objects with the substitute annotation or label set to
+// 'disabled' are left untouched. In strict mode, an error is returned for the
+// undefined variables without default value, which otherwise expand to an
+// empty string.
+func Substitute(objects []*unstructured.Unstructured, vars map[string]string, strict bool) error {
+	for _, u := range objects {
+		if u.GetAnnotations()[v1alpha1.SubstituteAnnotation] == v1alpha1.DisabledValue ||
+			u.GetLabels()[v1alpha1.SubstituteAnnotation] == v1alpha1.DisabledValue {
+			continue
+		}
+		if err := substituteObject(u, vars, strict); err != nil {
+			return fmt.Errorf("variable substitution failed for %s: %w", ssautil.FmtUnstructured(u), err)
+		}
+	}
+	return nil
+}
+
+// substituteObject replaces the ${var} expressions of the YAML encoding
+// of the object.
+func substituteObject(u *unstructured.Unstructured, vars map[string]string, strict bool) error {
+	data, err := yaml.Marshal(u.Object)
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(string(data), "${") {
+		return nil
+	}
+	output, err := Expand(string(data), vars, strict)
+	if err != nil {
+		return err
+	}
+	jsonData, err := yaml.YAMLToJSON([]byte(output))
+	if err != nil {
+		return err
+	}
+	result := &unstructured.Unstructured{}
+	if err := result.UnmarshalJSON(jsonData); err != nil {
+		return err
+	}
+	u.Object = result.Object
+	return nil
+}
+
+// Expand replaces the ${var} expressions of s with the value of the
+// variables. The ${var:=default} and ${var:-default} expressions expand
+// to the default value when the variable is undefined or empty, and the
+// ${var=default} and ${var-default} expressions when it is undefined.
+// The $${var} expressions are escaped to ${var}, and the expressions whose
+// name is not a valid variable name are left untouched.
+func Expand(s string, vars map[string]string, strict bool) (string, error) {
+	var b strings.Builder
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		if i > 0 && s[i-1] == '$' {
+			b.WriteString(s[:i-1] + "${")
+			s = s[i+2:]
+			continue
+		}
+		b.WriteString(s[:i])
+		end := strings.IndexByte(s[i:], '}')
+		if end < 0 {
+			b.WriteString(s[i:])
+			return b.String(), nil
+		}
+		expr := s[i+2 : i+end]
+		value, ok, err := expandExpression(expr, vars, strict)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			b.WriteString(value)
+		} else {
+			b.WriteString(s[i : i+end+1])
+		}
+		s = s[i+end+1:]
+	}
+}
+
+// expandExpression returns the value of the expression between the braces,
+// or false if the expression is not a variable reference.
+func expandExpression(expr string, vars map[string]string, strict bool) (string, bool, error) {
+	name, defaultValue, operator := expr, "", ""
+	for _, op := range []string{":=", ":-", "=", "-"} {
+		if n, d, found := strings.Cut(expr, op); found && varNameRegexp.MatchString(n) {
+			name, defaultValue, operator = n, d, op
+			break
+		}
+	}
+	if !varNameRegexp.MatchString(name) {
+		return "", false, nil
+	}
+
+	value, defined := vars[name]
+	switch {
+	case operator == "" && !defined:
+		if strict {
+			return "", false, fmt.Errorf("variable not set (strict mode): %q", name)
+		}
+		return "", true, nil
+	case strings.HasPrefix(operator, ":") && value == "",
+		operator != "" && !defined:
+		return defaultValue, true, nil
+	}
+	return value, true, nil
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2024 The KCL authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package postbuild
+
+import (
+	"strings"
+	"testing"
+
+	ssautil "github.com/fluxcd/pkg/ssa/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestExpand(t *testing.T) {
+	vars := map[string]string{
+		"cluster": "prod",
+		"region":  "eu-west-1",
+		"empty":   "",
+	}
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"${cluster}-${region}", "prod-eu-west-1"},
+		{"${missing}", ""},
+		{"${missing:=default}", "default"},
+		{"${missing:-default}", "default"},
+		{"${missing=default}", "default"},
+		{"${empty:=default}", "default"},
+		{"${empty=default}", ""},
+		{"${cluster:=default}", "prod"},
+		{"${missing:=a-b=c}", "a-b=c"},
+		{"$${cluster}", "${cluster}"},
+		{"$cluster", "$cluster"},
+		{"${not valid}", "${not valid}"},
+		{"${unterminated", "${unterminated"},
+	}
+	for _, tt := range tests {
+		got, err := Expand(tt.input, vars, false)
+		require.NoError(t, err, tt.input)
+		assert.Equal(t, tt.want, got, tt.input)
+	}
+
+	_, err := Expand("${missing}", vars, true)
+	assert.ErrorContains(t, err, `variable not set (strict mode): "missing"`)
+
+	got, err := Expand("${missing:=default}", vars, true)
+	require.NoError(t, err)
+	assert.Equal(t, "default", got)
+}
+
+func TestValidateVariables(t *testing.T) {
+	assert.NoError(t, ValidateVariables(map[string]string{"cluster_name": "prod", "_x1": ""}))
+	assert.ErrorContains(t, ValidateVariables(map[string]string{"cluster-name": "prod"}), "var name is invalid")
+}
+
+func TestSubstitute(t *testing.T) {
+	objects, err := ssautil.ReadObjects(strings.NewReader(`---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: cluster-${cluster}
+data:
+  region: ${region:=us-east-1}
+  replicas: "${replicas}"
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: script
+  annotations:
+    krm.kcl.dev.fluxcd/substitute: disabled
+data:
+  run.sh: echo ${HOME}
+`))
+	require.NoError(t, err)
+
+	vars := map[string]string{"cluster": "prod", "replicas": "3"}
+	require.NoError(t, Substitute(objects, vars, true))
+
+	assert.Equal(t, "cluster-prod", objects[0].GetName())
+	data, _, _ := unstructured.NestedMap(objects[0].Object, "data")
+	assert.Equal(t, map[string]interface{}{"region": "us-east-1", "replicas": int64(3)}, data)
+	data, _, _ = unstructured.NestedMap(objects[1].Object, "data")
+	assert.Equal(t, "echo ${HOME}", data["run.sh"])
+
+	objects[1].SetAnnotations(nil)
+	err = Substitute(objects, vars, true)
+	assert.ErrorContains(t, err, "variable substitution failed for ConfigMap/script")
+}
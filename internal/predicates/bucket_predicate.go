@@ -0,0 +1,70 @@
+/*
+Copyright 2024 The KCL authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package predicates
+
+import (
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	sourcev1beta2 "github.com/fluxcd/source-controller/api/v1beta2"
+)
+
+// BucketRevisionChangePredicate triggers an update event
+// when a Bucket revision changes.
+type BucketRevisionChangePredicate struct {
+	predicate.Funcs
+}
+
+func (BucketRevisionChangePredicate) Create(e event.CreateEvent) bool {
+	src, ok := e.Object.(*sourcev1beta2.Bucket)
+	if !ok || src.GetArtifact() == nil {
+		return false
+	}
+
+	return true
+}
+
+func (BucketRevisionChangePredicate) Update(e event.UpdateEvent) bool {
+	if e.ObjectOld == nil || e.ObjectNew == nil {
+		return false
+	}
+
+	oldSource, ok := e.ObjectOld.(*sourcev1beta2.Bucket)
+	if !ok {
+		return false
+	}
+
+	newSource, ok := e.ObjectNew.(*sourcev1beta2.Bucket)
+	if !ok {
+		return false
+	}
+
+	if oldSource.GetArtifact() == nil && newSource.GetArtifact() != nil {
+		return true
+	}
+
+	if oldSource.GetArtifact() != nil && newSource.GetArtifact() != nil &&
+		!oldSource.GetArtifact().HasRevision(newSource.GetArtifact().Revision) {
+		return true
+	}
+
+	return false
+}
+
+func (BucketRevisionChangePredicate) Delete(e event.DeleteEvent) bool {
+	return false
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2024 The KCL authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+
+	"github.com/kcl-lang/flux-kcl-controller/api/v1alpha1"
+)
+
+const (
+	// DefaultAudience is the default audience of the service account tokens
+	// used to log in to Vault, which the Vault role must bind.
+	DefaultAudience = "vault"
+	// DefaultAuthMount is the default mount path of the Kubernetes auth method.
+	DefaultAuthMount = "kubernetes"
+	// DefaultMount is the default mount path of the KV secrets engine.
+	DefaultMount = "secret"
+)
+
+// ErrSecretNotFound is returned when the secret does not exist, or when its
+// latest version is deleted.
+var ErrSecretNotFound = api.ErrSecretNotFound
+
+// ReadSecret logs in to the Vault server of the reference with the Kubernetes
+// auth method and the service account token jwt, and returns the data of the
+// secret at path in the KV secrets engine. The values which are not strings
+// are encoded in JSON. A plain HTTP server is refused unless the reference
+// is insecure.
+func ReadSecret(ctx context.Context, ref *v1alpha1.VaultReference, path, jwt string) (map[string]string, error) {
+	if !strings.HasPrefix(ref.Address, "https://") && !ref.Insecure {
+		return nil, fmt.Errorf("the Vault address '%s' is not https, set insecure to allow it", ref.Address)
+	}
+	config := api.DefaultConfig()
+	if config.Error != nil {
+		return nil, config.Error
+	}
+	config.Address = ref.Address
+	client, err := api.NewClient(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the Vault client: %w", err)
+	}
+	// Never use the token of the controller environment.
+	client.ClearToken()
+
+	authMount := ref.AuthMount
+	if authMount == "" {
+		authMount = DefaultAuthMount
+	}
+	login, err := client.Logical().WriteWithContext(ctx, fmt.Sprintf("auth/%s/login", strings.Trim(authMount, "/")),
+		map[string]interface{}{"role": ref.Role, "jwt": jwt})
+	if err != nil {
+		return nil, fmt.Errorf("failed to log in to Vault with the role '%s': %w", ref.Role, err)
+	}
+	if login == nil || login.Auth == nil || login.Auth.ClientToken == "" {
+		return nil, fmt.Errorf("failed to log in to Vault with the role '%s': no token returned", ref.Role)
+	}
+	client.SetToken(login.Auth.ClientToken)
+	// The token is only used for this read.
+	defer func() {
+		_ = client.Auth().Token().RevokeSelfWithContext(ctx, "")
+	}()
+
+	mount := ref.Mount
+	if mount == "" {
+		mount = DefaultMount
+	}
+	mount = strings.Trim(mount, "/")
+	path = strings.Trim(path, "/")
+	var secret *api.KVSecret
+	if ref.KVVersion == 1 {
+		secret, err = client.KVv1(mount).Get(ctx, path)
+	} else {
+		secret, err = client.KVv2(mount).Get(ctx, path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if secret.Data == nil {
+		return nil, fmt.Errorf("%w: at %s/%s", ErrSecretNotFound, mount, path)
+	}
+
+	data := make(map[string]string, len(secret.Data))
+	for k, v := range secret.Data {
+		if s, ok := v.(string); ok {
+			data[k] = s
+			continue
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode the value of key '%s': %w", k, err)
+		}
+		data[k] = string(b)
+	}
+	return data, nil
+}
+
+// IsNotFound returns true if the error is returned for a missing secret.
+func IsNotFound(err error) bool {
+	return errors.Is(err, ErrSecretNotFound)
+}
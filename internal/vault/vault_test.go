@@ -0,0 +1,152 @@
+/*
+Copyright 2024 The KCL authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kcl-lang/flux-kcl-controller/api/v1alpha1"
+)
+
+// devVault is a minimal stand-in of a Vault dev server, with a Kubernetes
+// auth method and KV secrets engines.
+type devVault struct {
+	mu sync.Mutex
+	// roles maps the auth mount and role to the accepted service account token.
+	roles map[string]string
+	// secrets maps the API paths of the KV secrets to their response data.
+	secrets map[string]interface{}
+	tokens  map[string]bool
+	revoked int
+}
+
+func newDevVault(t *testing.T) (*devVault, string) {
+	v := &devVault{roles: map[string]string{}, secrets: map[string]interface{}{}, tokens: map[string]bool{}}
+	server := httptest.NewServer(v)
+	t.Cleanup(server.Close)
+	return v, server.URL
+}
+
+func (v *devVault) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	path := strings.TrimPrefix(req.URL.Path, "/v1/")
+	switch {
+	case strings.HasPrefix(path, "auth/") && strings.HasSuffix(path, "/login"):
+		var body struct {
+			Role string `json:"role"`
+			JWT  string `json:"jwt"`
+		}
+		_ = json.NewDecoder(req.Body).Decode(&body)
+		mount := strings.TrimSuffix(strings.TrimPrefix(path, "auth/"), "/login")
+		if jwt, ok := v.roles[mount+"/"+body.Role]; !ok || jwt != body.JWT {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+		token := "s.token-" + body.Role
+		v.tokens[token] = true
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"auth": map[string]interface{}{"client_token": token, "lease_duration": 60},
+		})
+	case path == "auth/token/revoke-self":
+		delete(v.tokens, req.Header.Get("X-Vault-Token"))
+		v.revoked++
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		if !v.tokens[req.Header.Get("X-Vault-Token")] {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+		data, ok := v.secrets[path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"errors":[]}`))
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+	}
+}
+
+func TestReadSecret(t *testing.T) {
+	v, address := newDevVault(t)
+	v.roles["kubernetes/apps"] = "sa-token"
+	v.roles["k8s/legacy"] = "sa-token"
+	v.secrets["secret/data/apps/db"] = map[string]interface{}{
+		"data": map[string]interface{}{
+			"username": "admin",
+			"port":     5432,
+			"hosts":    []string{"a", "b"},
+		},
+		"metadata": map[string]interface{}{"version": 1},
+	}
+	v.secrets["kv/apps/db"] = map[string]interface{}{"password": "s3cr3t"}
+	// The controller environment token must not be used.
+	t.Setenv("VAULT_TOKEN", "root")
+
+	ctx := context.Background()
+	ref := &v1alpha1.VaultReference{Address: address, Role: "apps", Insecure: true}
+	data, err := ReadSecret(ctx, ref, "apps/db", "sa-token")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"username": "admin", "port": "5432", "hosts": `["a","b"]`}, data)
+
+	ref = &v1alpha1.VaultReference{Address: address, Role: "legacy", AuthMount: "k8s", Mount: "kv", KVVersion: 1,
+		Insecure: true}
+	data, err = ReadSecret(ctx, ref, "/apps/db", "sa-token")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"password": "s3cr3t"}, data)
+	assert.Equal(t, 2, v.revoked)
+	assert.Empty(t, v.tokens)
+}
+
+func TestReadSecretErrors(t *testing.T) {
+	v, address := newDevVault(t)
+	v.roles["kubernetes/apps"] = "sa-token"
+	v.secrets["secret/data/apps/deleted"] = map[string]interface{}{
+		"data":     nil,
+		"metadata": map[string]interface{}{"version": 2, "deletion_time": "2024-10-01T10:00:00Z"},
+	}
+
+	ctx := context.Background()
+	// The token is never sent over plain HTTP unless insecure is set.
+	ref := &v1alpha1.VaultReference{Address: address, Role: "apps"}
+	_, err := ReadSecret(ctx, ref, "apps/db", "sa-token")
+	assert.ErrorContains(t, err, "is not https")
+	assert.Empty(t, v.tokens)
+
+	ref.Insecure = true
+	_, err = ReadSecret(ctx, ref, "apps/db", "other-token")
+	assert.ErrorContains(t, err, "failed to log in to Vault with the role 'apps'")
+	assert.False(t, IsNotFound(err))
+
+	_, err = ReadSecret(ctx, ref, "apps/db", "sa-token")
+	assert.True(t, IsNotFound(err))
+
+	_, err = ReadSecret(ctx, ref, "apps/deleted", "sa-token")
+	assert.True(t, IsNotFound(err))
+}